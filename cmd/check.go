@@ -1,18 +1,27 @@
 package cmd
 
 import (
-	"fmt"
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/ops"
 
 	"github.com/spf13/cobra"
 )
 
 // checkCmd represents the check command
 var checkCmd = &cobra.Command{
-	Use:   "check",
+	Use:   "check <environment>",
 	Short: "Check the status of your servers and their current state",
-	Long:  `Check the status of your servers and their current state`,
+	Long: `Check the status of your servers and their current state using the checks of each group,
+without executing any task.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Check command not yet implemented!!!")
+		if len(args) == 0 {
+			logger.Fatal("No environment specified")
+		}
+
+		err := ops.CheckEnvironment(args[0], newConfig())
+		if err != nil {
+			logger.Fatal(err)
+		}
 	},
 }
 
@@ -13,7 +13,7 @@ var copyCmd = &cobra.Command{
 	Short: "Copy a file or directory between the local environment and a specified server",
 	Long:  `Copy a file or directory between the local environment and a specified server`,
 	Run: func(cmd *cobra.Command, args []string) {
-		err := ops.Copy(args[0], args[1], ops.NewConfig(AppConfig))
+		err := ops.Copy(args[0], args[1], newConfig())
 		if err != nil {
 			logger.Fatal(err)
 		}
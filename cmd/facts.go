@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/ops"
+
+	"github.com/spf13/cobra"
+)
+
+// factsCmd represents the facts command
+var factsCmd = &cobra.Command{
+	Use:   "facts <server>",
+	Short: "Show the facts gathered from a server as JSON",
+	Long: `Gather the facts of a server, like the distribution, kernel, architecture, memory, addresses, init system
+and package manager, and print them as JSON. Tasks use them as variables, for example ${facts.os_family}.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			logger.Fatal("Specify the name of the server you want to gather the facts from.")
+		}
+
+		config := newConfig()
+		config.SetFactsCache(factsCacheDir, factsCacheTTL)
+		err := ops.PrintFacts(args[0], config)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(factsCmd)
+
+	factsCmd.Flags().StringVar(&factsCacheDir, "facts-cache-dir", "", "directory used to cache the facts of the servers")
+	factsCmd.Flags().DurationVar(&factsCacheTTL, "facts-cache-ttl", time.Hour, "time the cached facts are used before gathering them again")
+}
@@ -1,14 +1,24 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/ops"
+	"github.com/Around25/shellbot/ssh"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
+var cliVariables []string
+var vaultPasswordFile string
+var vaultPassword string
 var AppConfig *viper.Viper
 
 // This represents the base command when called without any subcommands
@@ -38,6 +48,8 @@ func init() {
 	// will be global for your application.
 
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.shellbot.yaml)")
+	RootCmd.PersistentFlags().StringVar(&vaultPasswordFile, "vault-password-file", "", "file holding the vault password (default is $SHELLBOT_VAULT_PASSWORD or a prompt)")
+	RootCmd.PersistentFlags().StringArrayVar(&cliVariables, "var", nil, "set a variable as NAME=value, overriding any other definition")
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	//	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
@@ -57,10 +69,69 @@ func initConfig() {
 	AppConfig.AutomaticEnv()             // read in environment variables that match
 
 	// If a config file is found, read it in.
-	if err := AppConfig.ReadInConfig(); err == nil {
+	err := AppConfig.ReadInConfig()
+	if _, ok := err.(viper.ConfigParseError); ok {
+		// the whole file may be encrypted with the vault
+		err = readEncryptedConfig(AppConfig.ConfigFileUsed())
+	} else if err == nil {
+		// read the file again to decrypt the values tagged with !vault which the parser loads as they are
+		if err = readEncryptedConfig(AppConfig.ConfigFileUsed()); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	if err == nil {
 		fmt.Println("Using config file:", AppConfig.ConfigFileUsed())
 	} else {
 		fmt.Printf("%v\n", err)
 	}
 
 }
+
+// newConfig wraps the loaded configuration and applies the variables given with --var
+func newConfig() *ops.Config {
+	config := ops.NewConfig(AppConfig)
+	overrides, err := ops.ParseOverrides(cliVariables)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	config.SetOverrides(overrides)
+	if err = config.DecryptVault(vaultPassphrase); err != nil {
+		logger.Fatal(err)
+	}
+	if err = config.RegisterSecretProviders(); err != nil {
+		logger.Fatal(err)
+	}
+	return config
+}
+
+// readEncryptedConfig loads a config file that may be encrypted with the vault or contain values tagged with !vault
+func readEncryptedConfig(file string) error {
+	data, err := ops.ReadConfigFile(file, vaultPassphrase)
+	if err != nil {
+		return err
+	}
+	AppConfig.SetConfigType(strings.TrimPrefix(filepath.Ext(file), "."))
+	return AppConfig.ReadConfig(bytes.NewReader(data))
+}
+
+// vaultPassphrase loads the vault password from the password file, the environment or by prompting the user,
+// the password is only loaded once per run
+func vaultPassphrase() (string, error) {
+	if vaultPassword != "" {
+		return vaultPassword, nil
+	}
+
+	var err error
+	if vaultPasswordFile != "" {
+		data, readErr := ioutil.ReadFile(vaultPasswordFile)
+		if readErr != nil {
+			return "", fmt.Errorf("Unable to read vault password file %s: %s", vaultPasswordFile, readErr)
+		}
+		vaultPassword = strings.TrimRight(string(data), "\r\n")
+	} else if value := os.Getenv("SHELLBOT_VAULT_PASSWORD"); value != "" {
+		vaultPassword = value
+	} else {
+		vaultPassword, err = ssh.Prompt("Vault password: ", false)
+	}
+	return vaultPassword, err
+}
@@ -1,12 +1,19 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/Around25/shellbot/logger"
 	"github.com/Around25/shellbot/ops"
 
 	"github.com/spf13/cobra"
 )
 
+var setupNoStrict bool
+var setupCheck bool
+var factsCacheDir string
+var factsCacheTTL time.Duration
+
 // setupCmd represents the setup command
 var setupCmd = &cobra.Command{
 	Use:   "setup",
@@ -19,7 +26,11 @@ var setupCmd = &cobra.Command{
 		}
 		name = args[0]
 
-		err := ops.ProvisionEnvironment(name, ops.NewConfig(AppConfig))
+		config := newConfig()
+		config.SetStrict(!setupNoStrict)
+		config.SetFactsCache(factsCacheDir, factsCacheTTL)
+		config.SetCheckAfterSetup(setupCheck)
+		err := ops.ProvisionEnvironment(name, config)
 		if err != nil {
 			logger.Fatal(err)
 		}
@@ -28,4 +39,9 @@ var setupCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(setupCmd)
+
+	setupCmd.Flags().BoolVar(&setupCheck, "check", false, "verify the checks of each group on the servers after their tasks")
+	setupCmd.Flags().BoolVar(&setupNoStrict, "no-strict", false, "expand undefined variables to an empty string instead of failing")
+	setupCmd.Flags().StringVar(&factsCacheDir, "facts-cache-dir", "", "directory used to cache the facts of the servers")
+	setupCmd.Flags().DurationVar(&factsCacheTTL, "facts-cache-ttl", time.Hour, "time the cached facts are used before gathering them again")
 }
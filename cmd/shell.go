@@ -20,7 +20,7 @@ var shellCmd = &cobra.Command{
 
 		// get the name of the server as the first argument
 		name = args[0]
-		err := ops.OpenTerminalToServer(name, ops.NewConfig(AppConfig))
+		err := ops.OpenTerminalToServer(name, newConfig())
 		if err != nil {
 			logger.Fatal(err)
 		}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/ops"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	tunnelLocal   []string
+	tunnelRemote  []string
+	tunnelDynamic []string
+)
+
+// tunnelCmd represents the tunnel command
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel <server>",
+	Short: "Forward ports through a server until interrupted",
+	Long: `Open local (-L), remote (-R) and dynamic SOCKS5 (-D) port forwards through a server.
+The forwards use the same format as ssh and stay open until the command is interrupted.
+
+Example: shellbot tunnel dev-1 -L 5432:localhost:5432 -R 8080:localhost:80 -D 1080`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var name string
+		if len(args) == 0 {
+			logger.Fatal("Specify the name of the server you want to open the tunnel through.")
+		}
+
+		// get the name of the server as the first argument
+		name = args[0]
+		err := ops.OpenTunnel(name, tunnelLocal, tunnelRemote, tunnelDynamic, newConfig())
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(tunnelCmd)
+
+	tunnelCmd.Flags().StringArrayVarP(&tunnelLocal, "local", "L", nil, "local forward [bind_address:]port:host:hostport")
+	tunnelCmd.Flags().StringArrayVarP(&tunnelRemote, "remote", "R", nil, "remote forward [bind_address:]port:host:hostport")
+	tunnelCmd.Flags().StringArrayVarP(&tunnelDynamic, "dynamic", "D", nil, "SOCKS5 proxy on [bind_address:]port")
+}
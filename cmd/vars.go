@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/ops"
+
+	"github.com/spf13/cobra"
+)
+
+// varsCmd represents the vars command
+var varsCmd = &cobra.Command{
+	Use:   "vars <environment> <server> [group]",
+	Short: "Show the variables a server gets in an environment",
+	Long: `Show the variables resolved for a server in an environment and where each one was defined.
+From lowest to highest precedence variables come from the top level variables block, the environment,
+the group, the server, the OS environment and the --var flags.
+The variables are shown for every group of the environment containing the server, or only for the given group.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			logger.Fatal("Specify the environment and the name of the server.")
+		}
+
+		group := ""
+		if len(args) > 2 {
+			group = args[2]
+		}
+		err := ops.PrintVariables(args[0], group, args[1], newConfig())
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(varsCmd)
+}
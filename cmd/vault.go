@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/ops"
+	"github.com/Around25/shellbot/vault"
+
+	"github.com/spf13/cobra"
+)
+
+// vaultCmd represents the vault command
+var vaultCmd = &cobra.Command{
+	Use:   "vault",
+	Short: "Encrypt and decrypt secrets used in the configuration files",
+	Long: `Encrypt whole configuration files or single values with AES-GCM using a key derived from the vault password.
+The password is read from --vault-password-file, the SHELLBOT_VAULT_PASSWORD environment variable or prompted for.
+Encrypted files, values starting with vault: and values tagged with !vault followed by the encrypted value on the same line
+are decrypted when the configuration is loaded.`,
+}
+
+// vaultEncryptCmd represents the vault encrypt command
+var vaultEncryptCmd = &cobra.Command{
+	Use:   "encrypt <file>",
+	Short: "Encrypt a file in place",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			logger.Fatal("Specify the file you want to encrypt.")
+		}
+		if err := ops.EncryptFile(args[0], requireVaultPassphrase()); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Success(fmt.Sprintf("Encrypted %s", args[0]))
+	},
+}
+
+// vaultDecryptCmd represents the vault decrypt command
+var vaultDecryptCmd = &cobra.Command{
+	Use:   "decrypt <file>",
+	Short: "Decrypt a file in place",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			logger.Fatal("Specify the file you want to decrypt.")
+		}
+		if err := ops.DecryptFile(args[0], requireVaultPassphrase()); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Success(fmt.Sprintf("Decrypted %s", args[0]))
+	},
+}
+
+// vaultEditCmd represents the vault edit command
+var vaultEditCmd = &cobra.Command{
+	Use:   "edit <file>",
+	Short: "Edit an encrypted file using $EDITOR",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			logger.Fatal("Specify the file you want to edit.")
+		}
+		if err := ops.EditEncryptedFile(args[0], requireVaultPassphrase()); err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+// vaultEncryptStringCmd represents the vault encrypt-string command
+var vaultEncryptStringCmd = &cobra.Command{
+	Use:   "encrypt-string <value>",
+	Short: "Encrypt a single value to use in a configuration file",
+	Long: `Encrypt a single value and print it with the vault: prefix so it can be pasted in a configuration file.
+
+Example: shellbot vault encrypt-string 's3cret'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			logger.Fatal("Specify the value you want to encrypt.")
+		}
+		encrypted, err := vault.EncryptString(args[0], requireVaultPassphrase())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(encrypted)
+	},
+}
+
+// requireVaultPassphrase loads the vault password and stops the command if it is not available
+func requireVaultPassphrase() string {
+	passphrase, err := vaultPassphrase()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return passphrase
+}
+
+func init() {
+	RootCmd.AddCommand(vaultCmd)
+	vaultCmd.AddCommand(vaultEncryptCmd, vaultDecryptCmd, vaultEditCmd, vaultEncryptStringCmd)
+}
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"fmt"
 	"github.com/fatih/color"
 	"log"
 	"os"
@@ -8,36 +9,36 @@ import (
 
 func Fatal(values ...interface{}) {
 	color.Set(color.FgHiRed)
-	log.Println(values...)
+	log.Println(maskValues(values)...)
 	color.Unset()
 	os.Exit(1)
 }
 func Fatalf(format string, values ...interface{}) {
 	color.Set(color.FgHiRed)
-	log.Printf(format, values...)
+	log.Print(Mask(fmt.Sprintf(format, values...)))
 	color.Unset()
 	os.Exit(1)
 }
 
 func Warning(values ...interface{}) {
 	color.Set(color.FgYellow)
-	log.Println(values...)
+	log.Println(maskValues(values)...)
 	color.Unset()
 }
 
 func Success(values ...interface{}) {
 	color.Set(color.FgGreen)
-	log.Println(values...)
+	log.Println(maskValues(values)...)
 	color.Unset()
 }
 func Successf(format string, values ...interface{}) {
 	color.Set(color.FgGreen)
-	log.Printf(format, values...)
+	log.Print(Mask(fmt.Sprintf(format, values...)))
 	color.Unset()
 }
 
 func Info(values ...interface{}) {
 	color.Set(color.FgCyan)
-	log.Println(values...)
+	log.Println(maskValues(values)...)
 	color.Unset()
 }
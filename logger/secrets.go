@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// mask replaces the secrets in the output
+const mask = "********"
+
+var (
+	secrets      []string
+	secretsMutex sync.RWMutex
+)
+
+/**
+Register a value that must never be displayed, every occurrence is masked in the output
+*/
+func AddSecret(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	secrets = append(secrets, value)
+}
+
+/**
+Replace the registered secrets found in the text
+*/
+func Mask(text string) string {
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+	for _, secret := range secrets {
+		text = strings.Replace(text, secret, mask, -1)
+	}
+	return text
+}
+
+/**
+Mask the secrets in the values passed to the log functions
+*/
+func maskValues(values []interface{}) []interface{} {
+	masked := make([]interface{}, len(values))
+	for i, value := range values {
+		masked[i] = Mask(fmt.Sprint(value))
+	}
+	return masked
+}
@@ -0,0 +1,147 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/ssh"
+	"strings"
+)
+
+/**
+Check all the servers of an environment without executing their tasks
+*/
+func CheckEnvironment(env string, config *Config) error {
+	defer config.CloseConnections()
+
+	failed := 0
+	for _, group := range config.GetGroupsForEnv(env) {
+		checks, err := config.GetChecksForGroup(group)
+		if err != nil {
+			return fmt.Errorf("Invalid checks for group %s: %s", group, err)
+		}
+		if len(checks) == 0 {
+			continue
+		}
+		for _, server := range config.GetServersForGroup(group) {
+			variables, err := config.ResolveVariables(env, group, server)
+			if err != nil {
+				return err
+			}
+			client, err := ConnectToServer(server, config)
+			if err != nil {
+				return err
+			}
+			if variables, err = serverVariables(server, client, variables, config); err != nil {
+				return err
+			}
+			if err := ExecuteChecksOnServer(server, client, checks, variables, config); err != nil {
+				logger.Warning(err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Checks failed on %d servers", failed)
+	}
+	return nil
+}
+
+/**
+Set whether setup verifies the checks of each group after executing the tasks of a server, disabled by default
+*/
+func (config *Config) SetCheckAfterSetup(check bool) {
+	config.checkAfterSetup = check
+}
+
+/**
+Execute the checks on the connected server, report the result of each one and fail if any of them did not pass
+*/
+func ExecuteChecksOnServer(name string, client *ssh.Client, checks []map[string]string, variables Variables, config *Config) error {
+	failed := 0
+	for _, check := range checks {
+		expanded := make(map[string]string, len(check))
+		for key, value := range check {
+			var err error
+			if expanded[key], err = ExpandVariables(value, variables, config.strict); err != nil {
+				return fmt.Errorf("Unable to expand check %s: %s", key, err)
+			}
+		}
+
+		description, reason, err := ExecuteCheck(client, expanded, variables)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			logger.Warning(fmt.Sprintf("failed: %s (%s)", description, reason))
+			failed++
+		} else {
+			logger.Success(fmt.Sprintf("passed: %s", description))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed on server %s", failed, len(checks), name)
+	}
+	return nil
+}
+
+/**
+Execute a single check and return its description and the reason it failed, empty if it passed.
+The type of the check is given by the type field or by the field naming what is checked:
+service (with state running or stopped and enabled), file for exists and container for docker (with state).
+*/
+func ExecuteCheck(client *ssh.Client, check map[string]string, variables Variables) (string, string, error) {
+	kind := check["type"]
+	if kind == "" {
+		switch {
+		case check["service"] != "":
+			kind = "service"
+		case check["file"] != "":
+			kind = "exists"
+		case check["container"] != "":
+			kind = "docker"
+		}
+	}
+
+	switch kind {
+	case "service":
+		description := fmt.Sprintf("service: %s", check["service"])
+		reason, err := CheckService(client, check["service"], check["state"], check["enabled"], variables)
+		return description, reason, err
+	case "exists":
+		description := fmt.Sprintf("exists: %s", check["file"])
+		exists, err := client.Test("test -e "+ssh.ShellQuote(check["file"]), ssh.ExecuteOptions{})
+		if err != nil || exists {
+			return description, "", err
+		}
+		return description, fmt.Sprintf("%s does not exist", check["file"]), nil
+	case "docker":
+		description := fmt.Sprintf("docker: %s", check["container"])
+		reason, err := checkContainer(client, check["container"], check["state"])
+		return description, reason, err
+	}
+	return "", "", fmt.Errorf("Unknown check type: %s", kind)
+}
+
+/**
+Check that a docker container is running or stopped, running by default
+*/
+func checkContainer(client *ssh.Client, container string, state string) (string, error) {
+	output, err := client.Execute("docker inspect -f '{{.State.Running}}' " + ssh.ShellQuote(container))
+	if err != nil {
+		return fmt.Sprintf("container %s not found", container), nil
+	}
+	running := strings.TrimSpace(output) == "true"
+	switch state {
+	case "", "running":
+		if !running {
+			return fmt.Sprintf("container %s is not running", container), nil
+		}
+	case "stopped":
+		if running {
+			return fmt.Sprintf("container %s is running", container), nil
+		}
+	default:
+		return "", fmt.Errorf("Invalid state %s for docker check, expected running or stopped", state)
+	}
+	return "", nil
+}
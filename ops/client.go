@@ -0,0 +1,80 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"github.com/mitchellh/go-homedir"
+	"strconv"
+)
+
+/**
+Retrieve the client connected to the named server, connecting to it the first time it is used
+*/
+func ConnectToServer(name string, appConfig *Config) (*ssh.Client, error) {
+	return appConfig.connections.Get(name, func() (*ssh.Config, error) {
+		return newServerConfig(name, appConfig)
+	})
+}
+
+/**
+Create the ssh configuration for the named server based on the config file
+*/
+func newServerConfig(name string, appConfig *Config) (*ssh.Config, error) {
+	// load connection data from the configuration file
+	server, err := appConfig.GetServer(name)
+	if err != nil {
+		return nil, err
+	}
+	uri := server["uri"]
+	key, _ := homedir.Expand(server["key"])
+
+	if uri == "" {
+		return nil, fmt.Errorf("Missing connection string. Define your server in the configuration file first.")
+	}
+
+	sshConfig := ssh.NewConfig(uri, key, true, true, true)
+	sshConfig.CertFile, _ = homedir.Expand(server["cert"])
+	sshConfig.KnownHostsFile, _ = homedir.Expand(server["known_hosts"])
+	sshConfig.HostCAFile, _ = homedir.Expand(server["host_ca"])
+	sshConfig.PasswordEnv = server["password_env"]
+	sshConfig.PasswordCommand = server["password_command"]
+	sshConfig.BecomePassword = server["become_password"]
+	sshConfig.BecomePasswordEnv = server["become_password_env"]
+	if err = applyConnectionOptions(sshConfig, server); err != nil {
+		return nil, fmt.Errorf("Invalid configuration for server %s: %s", name, err)
+	}
+	return sshConfig, nil
+}
+
+/**
+Apply the optional connection properties of a server entry to the ssh config
+*/
+func applyConnectionOptions(sshConfig *ssh.Config, server map[string]string) error {
+	var err error
+	if value, ok := server["connect_timeout"]; ok {
+		if sshConfig.ConnectTimeout, err = parseDuration(value); err != nil {
+			return fmt.Errorf("connect_timeout: %s", err)
+		}
+	}
+	if value, ok := server["keepalive_interval"]; ok {
+		if sshConfig.KeepAliveInterval, err = parseDuration(value); err != nil {
+			return fmt.Errorf("keepalive_interval: %s", err)
+		}
+	}
+	if value, ok := server["keepalive_count_max"]; ok {
+		if sshConfig.KeepAliveCountMax, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("keepalive_count_max: %s", err)
+		}
+	}
+	if value, ok := server["max_sessions"]; ok {
+		if sshConfig.MaxSessions, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("max_sessions: %s", err)
+		}
+	}
+	if value, ok := server["forward_agent"]; ok {
+		if sshConfig.ForwardAgent, err = parseBool(value); err != nil {
+			return fmt.Errorf("forward_agent: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"os"
+	"os/exec"
+)
+
+/**
+Check the creates, unless and only_if options of a task and return the reason why it should be skipped, if any.
+The checks of local tasks are made on the local machine.
+*/
+func skipReason(client *ssh.Client, task *Task, options ssh.ExecuteOptions) (string, error) {
+	// the guards should not be limited by the timeout of the task itself
+	options.Timeout = 0
+	test := func(command string) (bool, error) {
+		if task.Type == "local" {
+			return testLocal(command, options)
+		}
+		return client.Test(command, options)
+	}
+
+	if path := task.Option("creates"); path != "" {
+		exists, err := test("test -e " + ssh.ShellQuote(path))
+		if err != nil {
+			return "", fmt.Errorf("Unable to check creates for task %s: %s", task.Type, err)
+		}
+		if exists {
+			return fmt.Sprintf("%s exists", path), nil
+		}
+	}
+	if command := task.Option("unless"); command != "" {
+		success, err := test(command)
+		if err != nil {
+			return "", fmt.Errorf("Unable to check unless for task %s: %s", task.Type, err)
+		}
+		if success {
+			return fmt.Sprintf("unless '%s' succeeded", command), nil
+		}
+	}
+	if command := task.Option("only_if"); command != "" {
+		success, err := test(command)
+		if err != nil {
+			return "", fmt.Errorf("Unable to check only_if for task %s: %s", task.Type, err)
+		}
+		if !success {
+			return fmt.Sprintf("only_if '%s' failed", command), nil
+		}
+	}
+	return "", nil
+}
+
+/**
+Execute a command on the local machine and report if it exited successfully
+*/
+func testLocal(command string, options ssh.ExecuteOptions) (bool, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = options.Cwd
+	cmd.Env = os.Environ()
+	for name, value := range options.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		return false, nil
+	}
+	return false, err
+}
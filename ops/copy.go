@@ -2,8 +2,6 @@ package ops
 
 import (
 	"fmt"
-	"github.com/Around25/shellbot/ssh"
-	"github.com/mitchellh/go-homedir"
 )
 
 func Copy(fromWithHost, toWithHost string, appConfig *Config) error {
@@ -20,23 +18,13 @@ func Copy(fromWithHost, toWithHost string, appConfig *Config) error {
 	} else {
 		name = toHost
 	}
-	// load connection data from the configuration file
-	server, err := appConfig.GetServer(name)
-	if err != nil {
-		return err
-	}
-	uri := server["uri"]
-	key, _ := homedir.Expand(server["key"])
 
 	// setup new connection to server
-	sshConfig := ssh.NewConfig(uri, key, true, true, true)
-	client := ssh.New(sshConfig)
-	defer client.Disconnect()
-
-	err = client.TryConnection()
+	client, err := ConnectToServer(name, appConfig)
 	if err != nil {
 		return err
 	}
+	defer appConfig.CloseConnections()
 
 	// start copy data transfer
 	if toHost != "" {
@@ -0,0 +1,228 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/**
+Facts holds the properties of a server gathered when it is provisioned, available to tasks as ${facts.name}
+*/
+type Facts map[string]string
+
+// factsScript prints the facts of a server as name=value lines using only POSIX shell and common tools
+const factsScript = `echo "os=$(uname -s | tr 'A-Z' 'a-z')"
+echo "kernel=$(uname -r)"
+echo "arch=$(uname -m)"
+echo "hostname=$(hostname 2>/dev/null || uname -n)"
+if [ -r /etc/os-release ]; then
+	( . /etc/os-release; echo "distribution=$ID"; echo "distribution_version=$VERSION_ID"; echo "distribution_like=$ID_LIKE" )
+fi
+echo "cpus=$(nproc 2>/dev/null || getconf _NPROCESSORS_ONLN 2>/dev/null)"
+echo "cpu_model=$(sed -n 's/^model name[[:space:]]*:[[:space:]]*//p' /proc/cpuinfo 2>/dev/null | head -n 1)"
+echo "memory_mb=$(awk '/^MemTotal:/ { print int($2 / 1024) }' /proc/meminfo 2>/dev/null)"
+echo "ip_addresses=$(hostname -I 2>/dev/null || ip -o -4 addr show scope global 2>/dev/null | awk '{ split($4, a, "/"); printf "%s ", a[1] }')"
+if [ -d /run/systemd/system ]; then
+	echo "init_system=systemd"
+elif command -v openrc >/dev/null 2>&1 || [ -x /sbin/openrc-run ]; then
+	echo "init_system=openrc"
+else
+	echo "init_system=sysvinit"
+fi
+for manager in apt-get dnf yum apk zypper pacman; do
+	if command -v $manager >/dev/null 2>&1; then
+		echo "package_manager=$manager"
+		break
+	fi
+done`
+
+// families of the known distributions, used to find the os_family fact
+var distributionFamilies = map[string]string{
+	"debian":    "debian",
+	"ubuntu":    "debian",
+	"linuxmint": "debian",
+	"raspbian":  "debian",
+	"rhel":      "redhat",
+	"centos":    "redhat",
+	"fedora":    "redhat",
+	"rocky":     "redhat",
+	"almalinux": "redhat",
+	"amzn":      "redhat",
+	"ol":        "redhat",
+	"alpine":    "alpine",
+	"arch":      "archlinux",
+	"manjaro":   "archlinux",
+	"opensuse":  "suse",
+	"sles":      "suse",
+	"suse":      "suse",
+}
+
+/**
+Gather the facts of the connected server: os, os_family, distribution, distribution_version, kernel, arch, cpus,
+cpu_model, memory_mb, hostname, ip_addresses, default_ipv4, init_system and package_manager
+*/
+func GatherFacts(client *ssh.Client) (Facts, error) {
+	output, err := client.Execute(factsScript)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to gather facts from server[%s]: %s", client.Config.Host, err)
+	}
+
+	facts := Facts{}
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) == 2 {
+			facts[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	facts["ip_addresses"] = strings.Join(strings.Fields(facts["ip_addresses"]), " ")
+	for _, address := range strings.Fields(facts["ip_addresses"]) {
+		if !strings.Contains(address, ":") {
+			facts["default_ipv4"] = address
+			break
+		}
+	}
+	if facts["package_manager"] == "apt-get" {
+		facts["package_manager"] = "apt"
+	}
+	facts["os_family"] = osFamily(facts)
+	return facts, nil
+}
+
+/**
+Find the family of the distribution using its id, the ids it is like and finally the name of the os
+*/
+func osFamily(facts Facts) string {
+	ids := append([]string{facts["distribution"]}, strings.Fields(facts["distribution_like"])...)
+	for _, id := range ids {
+		if family, ok := distributionFamilies[strings.Trim(id, `"`)]; ok {
+			return family
+		}
+		if strings.HasPrefix(id, "opensuse") {
+			return "suse"
+		}
+	}
+	return facts["os"]
+}
+
+/**
+Retrieve the facts of a server, gathered once per run and cached on disk when a cache directory is set
+*/
+func (config *Config) GetFacts(name string, client *ssh.Client) (Facts, error) {
+	if facts, ok := config.facts[name]; ok {
+		return facts, nil
+	}
+	if facts := config.readCachedFacts(name); facts != nil {
+		config.facts[name] = facts
+		return facts, nil
+	}
+
+	facts, err := GatherFacts(client)
+	if err != nil {
+		return nil, err
+	}
+	config.facts[name] = facts
+	if err := config.writeCachedFacts(name, facts); err != nil {
+		return nil, err
+	}
+	return facts, nil
+}
+
+/**
+Cache the facts of the servers in a directory for the given duration, an empty directory disables the cache
+*/
+func (config *Config) SetFactsCache(dir string, ttl time.Duration) {
+	config.factsCacheDir = dir
+	config.factsCacheTTL = ttl
+}
+
+/**
+Check if the facts should be gathered for a server, it can be disabled for every server with a top level
+gather_facts: false or only for some servers with gather_facts: false in their entry
+*/
+func (config *Config) gatherFacts(name string) (bool, error) {
+	value := config.config.GetString("servers." + name + ".gather_facts")
+	if value == "" {
+		value = config.config.GetString("gather_facts")
+	}
+	if value == "" {
+		return true, nil
+	}
+	gather, err := parseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid gather_facts for server %s: %s", name, err)
+	}
+	return gather, nil
+}
+
+/**
+Read the facts of a server from the cache, nil if they are not cached or have expired
+*/
+func (config *Config) readCachedFacts(name string) Facts {
+	if config.factsCacheDir == "" {
+		return nil
+	}
+	file := filepath.Join(config.factsCacheDir, name+".json")
+	info, err := os.Stat(file)
+	if err != nil || (config.factsCacheTTL > 0 && time.Since(info.ModTime()) > config.factsCacheTTL) {
+		return nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var facts Facts
+	if err := json.Unmarshal(content, &facts); err != nil {
+		return nil
+	}
+	return facts
+}
+
+/**
+Write the facts of a server to the cache if a cache directory is set
+*/
+func (config *Config) writeCachedFacts(name string, facts Facts) error {
+	if config.factsCacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(config.factsCacheDir, 0700); err != nil {
+		return fmt.Errorf("Unable to create facts cache directory %s: %s", config.factsCacheDir, err)
+	}
+	content, err := json.MarshalIndent(facts, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(config.factsCacheDir, name+".json")
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		return fmt.Errorf("Unable to write facts cache %s: %s", file, err)
+	}
+	return nil
+}
+
+/**
+Print the facts of a server as JSON
+*/
+func PrintFacts(name string, appConfig *Config) error {
+	client, err := ConnectToServer(name, appConfig)
+	if err != nil {
+		return err
+	}
+	defer appConfig.CloseConnections()
+
+	facts, err := appConfig.GetFacts(name, client)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(facts, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
@@ -0,0 +1,51 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// localEnvNamePattern matches the variables that can be passed to local commands through the environment
+var localEnvNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+/**
+Execute the command of a local task on the control machine with the variables available in its environment.
+Variable names are lowercased when the config is loaded so every variable is exported in lowercase and in uppercase,
+a script can read ${APP_DIR} as well as ${app_dir}.
+*/
+func ExecuteLocalTask(task *Task, options ssh.ExecuteOptions, variables Variables) (string, error) {
+	ctx := context.Background()
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	values, err := resolveSecretVariables(variables)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", task.Value)
+	cmd.Dir = options.Cwd
+	cmd.Env = os.Environ()
+	for name, value := range values {
+		if localEnvNamePattern.MatchString(name) {
+			cmd.Env = append(cmd.Env, name+"="+value, strings.ToUpper(name)+"="+value)
+		}
+	}
+	for name, value := range options.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return string(output), fmt.Errorf("Command timed out after %s: %s", options.Timeout, task.Value)
+	}
+	return string(output), err
+}
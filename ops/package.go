@@ -0,0 +1,168 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"strings"
+)
+
+/**
+packageManager holds the commands used to manage packages with a package manager.
+The checks are shell conditions using $p as the name of the package, the actions are followed by the package names.
+*/
+type packageManager struct {
+	installed string
+	outdated  string
+	install   string
+	upgrade   string
+	remove    string
+	update    string
+}
+
+// package managers supported by the package task by the name found in the package_manager fact
+var packageManagers = map[string]packageManager{
+	"apt": {
+		installed: `dpkg-query -W -f='${Status}' "$p" 2>/dev/null | grep -q 'install ok installed'`,
+		outdated:  `apt-cache policy "$p" | awk '/Installed:/ { i = $2 } /Candidate:/ { c = $2 } END { exit !(i != c) }'`,
+		install:   "DEBIAN_FRONTEND=noninteractive apt-get install -y -q -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold",
+		upgrade:   "DEBIAN_FRONTEND=noninteractive apt-get install -y -q --only-upgrade -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold",
+		remove:    "DEBIAN_FRONTEND=noninteractive apt-get remove -y -q",
+		update:    "DEBIAN_FRONTEND=noninteractive apt-get update -q",
+	},
+	"dnf": {
+		installed: `rpm -q "$p" >/dev/null 2>&1`,
+		outdated:  `dnf -q check-update "$p" >/dev/null 2>&1; [ $? -eq 100 ]`,
+		install:   "dnf install -y -q",
+		upgrade:   "dnf upgrade -y -q",
+		remove:    "dnf remove -y -q",
+		update:    "dnf makecache -y -q",
+	},
+	"yum": {
+		installed: `rpm -q "$p" >/dev/null 2>&1`,
+		outdated:  `yum -q check-update "$p" >/dev/null 2>&1; [ $? -eq 100 ]`,
+		install:   "yum install -y -q",
+		upgrade:   "yum update -y -q",
+		remove:    "yum remove -y -q",
+		update:    "yum makecache -y -q",
+	},
+	"apk": {
+		installed: `apk info -e "$p" >/dev/null 2>&1`,
+		outdated:  `apk list -u "$p" 2>/dev/null | grep -q .`,
+		install:   "apk add --no-progress",
+		upgrade:   "apk add --no-progress --upgrade",
+		remove:    "apk del --no-progress",
+		update:    "apk update --no-progress",
+	},
+}
+
+// detectPackageManagerCommand prints the package manager available on the server when no facts were gathered
+const detectPackageManagerCommand = `for manager in apt-get dnf yum apk; do
+	if command -v $manager >/dev/null 2>&1; then
+		echo $manager
+		break
+	fi
+done`
+
+/**
+Install, upgrade or remove the packages listed in the value of the task depending on its state: present (the default),
+latest or absent. The package manager is found using the package_manager fact, the package index is refreshed first
+if update_cache is set and the task is only reported as changed if a package was installed, upgraded or removed.
+*/
+func ExecutePackageTask(client *ssh.Client, task *Task, options ssh.ExecuteOptions, variables Variables) (*TaskResult, error) {
+	packages := strings.Fields(task.Value)
+	if len(packages) == 0 {
+		return &TaskResult{}, fmt.Errorf("No packages given to the package task")
+	}
+	state := task.Option("state")
+	if state == "" {
+		state = "present"
+	}
+	updateCache, err := task.Bool("update_cache")
+	if err != nil {
+		return &TaskResult{}, err
+	}
+
+	name, err := findPackageManager(client, variables)
+	if err != nil {
+		return &TaskResult{}, err
+	}
+	manager, ok := packageManagers[name]
+	if !ok {
+		return &TaskResult{}, fmt.Errorf("Unsupported package manager %s", name)
+	}
+
+	result := &TaskResult{}
+	if updateCache {
+		output, err := client.ExecuteWithOptions(manager.update, options)
+		result.Output += output
+		if err != nil {
+			return result, fmt.Errorf("Unable to update the package cache: %s", err)
+		}
+	}
+
+	// find the packages that need a change
+	var condition string
+	switch state {
+	case "present":
+		condition = fmt.Sprintf(`if ! %s; then echo "install $p"; fi`, manager.installed)
+	case "latest":
+		condition = fmt.Sprintf(`if ! %s; then echo "install $p"; elif %s; then echo "upgrade $p"; fi`, manager.installed, manager.outdated)
+	case "absent":
+		condition = fmt.Sprintf(`if %s; then echo "remove $p"; fi`, manager.installed)
+	default:
+		return result, fmt.Errorf("Invalid state %s for package task, expected present, latest or absent", state)
+	}
+	quoted := make([]string, len(packages))
+	for i, name := range packages {
+		quoted[i] = ssh.ShellQuote(name)
+	}
+	output, err := client.ExecuteWithOptions(fmt.Sprintf("for p in %s; do %s; done", strings.Join(quoted, " "), condition), options)
+	if err != nil {
+		return result, fmt.Errorf("Unable to check the packages %s: %s", task.Value, err)
+	}
+
+	changes := map[string][]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			changes[fields[0]] = append(changes[fields[0]], ssh.ShellQuote(fields[1]))
+		}
+	}
+	actions := []struct{ name, command string }{
+		{"install", manager.install},
+		{"upgrade", manager.upgrade},
+		{"remove", manager.remove},
+	}
+	for _, action := range actions {
+		if len(changes[action.name]) == 0 {
+			continue
+		}
+		output, err := client.ExecuteWithOptions(action.command+" "+strings.Join(changes[action.name], " "), options)
+		result.Output += output
+		if err != nil {
+			return result, fmt.Errorf("Unable to %s %s: %s", action.name, strings.Join(changes[action.name], " "), err)
+		}
+		result.Changed = true
+	}
+	return result, nil
+}
+
+/**
+Find the package manager of the server from its facts or by looking for the known package managers
+*/
+func findPackageManager(client *ssh.Client, variables Variables) (string, error) {
+	if name := variables["facts.package_manager"].Value; name != "" {
+		return name, nil
+	}
+	output, err := client.Execute(detectPackageManagerCommand)
+	if err != nil {
+		return "", fmt.Errorf("Unable to detect the package manager: %s", err)
+	}
+	name := strings.TrimSpace(output)
+	if name == "" {
+		return "", fmt.Errorf("No supported package manager found on server[%s]", client.Config.Host)
+	}
+	if name == "apt-get" {
+		name = "apt"
+	}
+	return name, nil
+}
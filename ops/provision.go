@@ -2,19 +2,29 @@ package ops
 
 import (
 	"fmt"
+	"github.com/Around25/shellbot/logger"
 	"github.com/Around25/shellbot/ssh"
-	"github.com/mitchellh/go-homedir"
+	"strings"
+	"time"
 )
 
+// default delay before retrying a failed task, doubled after each attempt
+const defaultRetryDelay = 5 * time.Second
+
+// default time to wait for a server to come back after a reboot task
+const defaultRebootTimeout = 5 * time.Minute
+
 /**
 Provision an environment based on the loaded configuration file
 */
 func ProvisionEnvironment(env string, config *Config) error {
+	// the connections are shared by all groups so close them only at the end
+	defer config.CloseConnections()
+
 	groups := config.GetGroupsForEnv(env)
-	variables := config.GetVariablesForEnv(env)
 
 	for _, group := range groups {
-		err := ProvisionGroup(group, variables, config)
+		err := ProvisionGroup(env, group, config)
 		if err != nil {
 			return err
 		}
@@ -23,15 +33,43 @@ func ProvisionEnvironment(env string, config *Config) error {
 }
 
 /**
-Provision a specific group from the config using the given variables
+Provision a specific group from the config using the variables of the environment
 */
-func ProvisionGroup(group string, variables map[string]string, config *Config) error {
+func ProvisionGroup(env string, group string, config *Config) error {
 	servers := config.GetServersForGroup(group)
-	tasks := config.GetTasksForGroup(group)
-	checks := config.GetChecksForGroup(group)
+	tasks, err := config.GetTasksForGroup(group)
+	if err != nil {
+		return fmt.Errorf("Invalid tasks for group %s: %s", group, err)
+	}
+	var checks []map[string]string
+	if config.checkAfterSetup {
+		if checks, err = config.GetChecksForGroup(group); err != nil {
+			return fmt.Errorf("Invalid checks for group %s: %s", group, err)
+		}
+	}
+
+	handlers, err := config.GetHandlersForGroup(group)
+	if err != nil {
+		return fmt.Errorf("Invalid handlers for group %s: %s", group, err)
+	}
+
+	// tasks and handlers inherit the options defined for the whole group
+	options := config.GetOptionsForGroup(group)
+	for _, task := range tasks {
+		task.Inherit(options)
+	}
+	for _, handlerTasks := range handlers {
+		for _, task := range handlerTasks {
+			task.Inherit(options)
+		}
+	}
 
 	for _, server := range servers {
-		err := ProvisionServer(server, tasks, checks, variables, config)
+		variables, err := config.ResolveVariables(env, group, server)
+		if err != nil {
+			return err
+		}
+		err = ProvisionServer(server, tasks, handlers, checks, variables, config)
 		if err != nil {
 			return err
 		}
@@ -40,86 +78,338 @@ func ProvisionGroup(group string, variables map[string]string, config *Config) e
 }
 
 /**
-Provision a single server with the list of tasks and variables
+Provision a single server with the list of tasks and variables, the handlers notified by the tasks run at the end
 */
-func ProvisionServer(name string, tasks []map[string]string, checks []map[string]string, variables map[string]string, config *Config) error {
-	// load connection data from the configuration file
-	server, err := config.GetServer(name)
+func ProvisionServer(name string, tasks []*Task, handlers map[string][]*Task, checks []map[string]string, variables Variables, config *Config) error {
+	// retrieve the client connected to the server
+	client, err := ConnectToServer(name, config)
 	if err != nil {
 		return err
 	}
-	uri := server["uri"]
-	key, _ := homedir.Expand(server["key"])
-
-	if uri == "" {
-		return fmt.Errorf("Missing connection string. Define your server in the configuration file first.")
-	}
 
-	// create a new client
-	sshConfig := ssh.NewConfig(uri, key, true, true, true)
-	client := ssh.New(sshConfig)
-	defer client.Disconnect()
-
-	// test the connection
-	err = client.TryConnection()
+	variables, err = serverVariables(name, client, variables, config)
 	if err != nil {
-		return fmt.Errorf("Unable to connect to server using uri '%s' key '%s' got: %s", uri, key, err)
+		return err
 	}
 
 	// execute tasks on the current server
-	err = ExecuteTasksOnServer(client, tasks, variables, config)
+	result, err := ExecuteTasksOnServer(client, tasks, variables, config)
 	if err != nil {
 		return err
 	}
+	if err = executeHandlers(client, result.Notified, handlers, variables, config); err != nil {
+		return err
+	}
 
-	// @todo Execute checks on server
-	return nil
+	// verify the state of the server once all tasks were executed, only when requested
+	if len(checks) == 0 {
+		return nil
+	}
+	return ExecuteChecksOnServer(name, client, checks, variables, config)
+}
+
+/**
+Copy the variables for a server and add its facts as ${facts.name}.
+Every server gets its own copy of the variables since tasks can register new ones.
+*/
+func serverVariables(name string, client *ssh.Client, variables Variables, config *Config) (Variables, error) {
+	variables = copyVariables(variables)
+
+	gather, err := config.gatherFacts(name)
+	if err != nil || !gather {
+		return variables, err
+	}
+	facts, err := config.GetFacts(name, client)
+	if err != nil {
+		return nil, err
+	}
+	for fact, value := range facts {
+		variables["facts."+fact] = Variable{Value: value, Source: "facts"}
+	}
+	return variables, nil
 }
 
 /**
 Execute a list of tasks on the connected server based on the provided config and with the list of variables as the current context
 */
-func ExecuteTasksOnServer(client *ssh.Client, tasks []map[string]string, variables map[string]string, config *Config) error {
+func ExecuteTasksOnServer(client *ssh.Client, tasks []*Task, variables Variables, config *Config) (*TaskResult, error) {
+	result := &TaskResult{}
 	for _, task := range tasks {
-		for taskType, taskValue := range task {
-			taskValue = ExpandVariables(taskValue, variables)
-			output, err := ExecuteTaskOnServer(client, taskType, taskValue, config)
+		taskResult, err := executeTaskWithLoop(client, task, variables, config)
+
+		// make the result available to the next tasks
+		if name := task.Option("register"); name != "" && taskResult != nil && variables != nil {
+			registerResult(name, taskResult, variables)
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Changed = result.Changed || taskResult.Changed
+		result.Notify(taskResult.Notified...)
+	}
+	return result, nil
+}
+
+/**
+Execute the handlers notified by the tasks of a server in the order they were notified.
+Every handler runs once even if it was notified several times and may notify other handlers.
+*/
+func executeHandlers(client *ssh.Client, notified []string, handlers map[string][]*Task, variables Variables, config *Config) error {
+	executed := map[string]bool{}
+	for i := 0; i < len(notified); i++ {
+		name := strings.ToLower(notified[i])
+		if executed[name] {
+			continue
+		}
+		executed[name] = true
+
+		tasks, ok := handlers[name]
+		if !ok {
+			return fmt.Errorf("Unknown handler %s", notified[i])
+		}
+		logger.Info(fmt.Sprintf("handler: %s", notified[i]))
+		result, err := ExecuteTasksOnServer(client, tasks, variables, config)
+		if err != nil {
+			return err
+		}
+		notified = append(notified, result.Notified...)
+	}
+	return nil
+}
+
+/**
+Execute a task once or, if it has a loop, once for every item available as ${item} or ${item.field}
+*/
+func executeTaskWithLoop(client *ssh.Client, task *Task, variables Variables, config *Config) (*TaskResult, error) {
+	items, err := task.LoopItems(variables, config.strict)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		return executeTask(client, task, variables, config)
+	}
+	if len(items) == 0 {
+		result := &TaskResult{Skipped: true, SkipReason: "loop has no items"}
+		reportTask(task, result)
+		return result, nil
+	}
+
+	var results []*TaskResult
+	for _, item := range items {
+		values := map[string]string{}
+		flattenVariables("item", item, values)
+		itemVariables := copyVariables(variables)
+		itemVariables.setValues(values, "loop")
+		result, err := executeTask(client, task, itemVariables, config)
+		if result != nil {
+			results = append(results, result)
+		}
+		if err != nil {
+			return combineResults(results), err
+		}
+	}
+	return combineResults(results), nil
+}
+
+/**
+Expand the variables of a single task, execute it and report its status
+*/
+func executeTask(client *ssh.Client, task *Task, variables Variables, config *Config) (*TaskResult, error) {
+	expanded, err := task.Expand(variables, config.strict)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to expand task %s: %s: %s", task.Type, task.Value, err)
+	}
+
+	// tasks marked with run_once are executed only on the first server of the run
+	runOnce, err := expanded.Bool("run_once")
+	if err != nil {
+		return nil, err
+	}
+	if runOnce && !config.markExecuted(expanded.Type+": "+expanded.Value) {
+		result := &TaskResult{Skipped: true, SkipReason: "run_once"}
+		reportTask(expanded, result)
+		return result, nil
+	}
+
+	result, err := ExecuteTaskWithRetries(client, expanded, variables, config)
+	if err != nil {
+		return result, err
+	}
+	// the handlers are only notified when the task changed the server
+	if result.Changed {
+		result.Notify(expanded.StringList("notify")...)
+	}
+	fmt.Print(logger.Mask(result.Output))
+	reportTask(expanded, result)
+	return result, nil
+}
+
+/**
+Report the status of a task after it was executed
+*/
+func reportTask(task *Task, result *TaskResult) {
+	if task.Type == "task" && !result.Skipped {
+		// the tasks of the group have already been reported
+		return
+	}
+	if result.Skipped {
+		logger.Info(fmt.Sprintf("skipped: %s: %s (%s)", task.Type, task.Value, result.SkipReason))
+	} else if result.Changed {
+		logger.Success(fmt.Sprintf("changed: %s: %s", task.Type, task.Value))
+	} else {
+		logger.Info(fmt.Sprintf("ok: %s: %s", task.Type, task.Value))
+	}
+}
+
+/**
+Execute the current task on the server unless its guards skip it and retry it with an increasing delay if it fails
+*/
+func ExecuteTaskWithRetries(client *ssh.Client, task *Task, variables Variables, config *Config) (*TaskResult, error) {
+	retries, err := task.Int("retries")
+	if err != nil {
+		return nil, err
+	}
+	delay, err := task.Duration("retry_delay")
+	if err != nil {
+		return nil, err
+	}
+	if delay == 0 {
+		delay = defaultRetryDelay
+	}
+
+	// skip the task if its condition is false
+	if when := task.Option("when"); when != "" {
+		ok, err := EvaluateCondition(when, variables, config.strict)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return &TaskResult{Skipped: true, SkipReason: fmt.Sprintf("when '%s' is false", when)}, nil
+		}
+	}
+
+	// skip the task if its guards say it is not needed
+	options, err := executeOptions(task)
+	if err != nil {
+		return nil, err
+	}
+	reason, err := skipReason(client, task, options)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		return &TaskResult{Skipped: true, SkipReason: reason}, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		result, err := ExecuteTaskOnServer(client, task, variables, config)
+		if err == nil || attempt >= retries {
 			if err != nil {
-				return err
+				fmt.Print(logger.Mask(result.Output))
 			}
-			fmt.Print(output)
+			return result, err
 		}
+		fmt.Print(logger.Mask(result.Output))
+		logger.Warning(fmt.Sprintf("Task %s failed (%s), retrying in %s (%d/%d)", task.Type, err, delay, attempt+1, retries))
+		time.Sleep(delay)
+		delay *= 2
 	}
-	return nil
 }
 
 /**
-Execute the current task on the server
+Execute the current task on the server, the variables used by the value and options of the task must already be expanded
 */
-func ExecuteTaskOnServer(client *ssh.Client, taskType string, taskValue string, config *Config) (string, error) {
-	switch taskType {
+func ExecuteTaskOnServer(client *ssh.Client, task *Task, variables Variables, config *Config) (*TaskResult, error) {
+	options, err := executeOptions(task)
+	if err != nil {
+		return &TaskResult{}, err
+	}
+
+	switch task.Type {
 	case "run":
-		return client.Execute(taskValue)
+		return changedResult(client.ExecuteWithOptions(task.Value, options))
+	case "reboot":
+		if options.Timeout == 0 {
+			options.Timeout = defaultRebootTimeout
+		}
+		return changedResult("", client.Reboot(task.Value, options))
+	case "script":
+		return changedResult(ExecuteScriptOnServer(client, task, options, variables, config.strict))
+	case "local":
+		return changedResult(ExecuteLocalTask(task, options, variables))
+	case "template":
+		return ExecuteTemplateOnServer(client, task, options, variables, config.strict)
+	case "package":
+		return ExecutePackageTask(client, task, options, variables)
+	case "service":
+		return ExecuteServiceTask(client, task, options, variables)
 	case "task":
-		return ExecuteTaskGroupOnServer(client, taskValue, config)
+		return ExecuteTaskGroupOnServer(client, task, task.Value, variables, config)
 	case "copy":
-		from, to := splitPaths(taskValue)
-		return "", client.Copy(from, to)
+		from, to := splitPaths(task.Value)
+		if options.Become {
+			return changedResult("", client.CopyAs(from, to, options))
+		}
+		return changedResult("", client.Copy(from, to))
 	case "download":
-		from, to := splitPaths(taskValue)
-		return "", client.Download(from, to)
+		from, to := splitPaths(task.Value)
+		return changedResult("", client.Download(from, to))
 	}
-	return "", fmt.Errorf("Unknown task type: %s", taskType)
+	return &TaskResult{}, fmt.Errorf("Unknown task type: %s", task.Type)
 }
 
 /**
-Execute a task group on the server
+Load the options used to execute the commands of a task
 */
-func ExecuteTaskGroupOnServer(client *ssh.Client, group string, config *Config) (string, error) {
-	tasks := config.GetTasksForTaskGroup(group)
-	err := ExecuteTasksOnServer(client, tasks, nil, config)
+func executeOptions(task *Task) (ssh.ExecuteOptions, error) {
+	var options ssh.ExecuteOptions
+	var err error
+	if options.Timeout, err = task.Duration("timeout"); err != nil {
+		return options, err
+	}
+	if options.ForwardAgent, err = task.Bool("forward_agent"); err != nil {
+		return options, err
+	}
+	if options.Become, err = task.Bool("become"); err != nil {
+		return options, err
+	}
+	options.BecomeUser = task.Option("become_user")
+	options.Cwd = task.Option("cwd")
+	if options.Env, err = task.StringMap("env"); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+/**
+Execute a task group on the server.
+The tasks of the group see the defaults declared by the group, the variables of the caller and the parameters
+given in the vars field of the task, in increasing order of precedence, so a caller variable with the same name
+as a default replaces it. Variables registered inside the group do not leak to the caller.
+*/
+func ExecuteTaskGroupOnServer(client *ssh.Client, parent *Task, group string, variables Variables, config *Config) (*TaskResult, error) {
+	tasks, err := config.GetTasksForTaskGroup(group)
 	if err != nil {
-		return "", err
+		return &TaskResult{}, err
+	}
+	// the options of the parent are already expanded so the tasks must not expand them again
+	inherited := make(map[string]interface{}, len(parent.Options))
+	for name, value := range parent.Options {
+		inherited[name] = markExpanded(value)
+	}
+	for _, task := range tasks {
+		task.Inherit(inherited)
+	}
+
+	scope := config.GetDefaultsForTaskGroup(group)
+	for key, value := range variables {
+		scope[key] = value
+	}
+	// the parameters come from the expanded task so their values are used as they are
+	if fields, err := toStringMap(parent.Raw); err == nil {
+		values := map[string]string{}
+		flattenVariables("", fields["vars"], values)
+		scope.setValues(values, "vars of task "+group)
 	}
-	return "", nil
+	return ExecuteTasksOnServer(client, tasks, scope, config)
 }
@@ -0,0 +1,40 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"io/ioutil"
+	"strings"
+)
+
+/**
+Upload a local script to the server and execute it with the arguments given after the path of the script
+*/
+func ExecuteScriptOnServer(client *ssh.Client, task *Task, options ssh.ExecuteOptions, variables Variables, strict bool) (string, error) {
+	parts := strings.SplitN(strings.TrimSpace(task.Value), " ", 2)
+	file := parts[0]
+	arguments := ""
+	if len(parts) > 1 {
+		arguments = strings.TrimSpace(parts[1])
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read script %s: %s", file, err)
+	}
+
+	// replace the variables in the contents of the script only when asked since scripts use $ a lot
+	expand, err := task.Bool("expand")
+	if err != nil {
+		return "", err
+	}
+	if expand {
+		expanded, err := ExpandVariables(string(content), variables, strict)
+		if err != nil {
+			return "", fmt.Errorf("Unable to expand script %s: %s", file, err)
+		}
+		content = []byte(expanded)
+	}
+
+	return client.ExecuteScript(file, content, arguments, task.Option("interpreter"), options)
+}
@@ -0,0 +1,171 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/logger"
+	"github.com/mitchellh/go-homedir"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+/**
+SecretProvider resolves a secret from an external source, ${name:key} calls the provider registered as name with key
+*/
+type SecretProvider interface {
+	Resolve(key string) (string, error)
+}
+
+var (
+	// providers available in every configuration, more can be added with RegisterSecretProvider
+	secretProviders = map[string]SecretProvider{
+		"file": fileSecretProvider{},
+		"cmd":  commandSecretProvider{},
+	}
+	// secrets already resolved during the run by provider and key
+	secretCache = map[string]string{}
+	secretMutex sync.Mutex
+)
+
+/**
+Register a provider available as ${name:key} in variables and tasks
+*/
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	secretProviders[name] = provider
+}
+
+/**
+Resolve a secret using the named provider, secrets are only resolved once per run and are masked in the output
+*/
+func resolveSecret(name string, key string) (string, error) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+
+	cacheKey := name + ":" + key
+	if value, ok := secretCache[cacheKey]; ok {
+		return value, nil
+	}
+	provider, ok := secretProviders[name]
+	if !ok {
+		return "", fmt.Errorf("Unknown secret provider %s", name)
+	}
+	value, err := provider.Resolve(key)
+	if err != nil {
+		return "", fmt.Errorf("Unable to resolve ${%s:%s}: %s", name, key, err)
+	}
+	logger.AddSecret(value)
+	secretCache[cacheKey] = value
+	return value, nil
+}
+
+/**
+Split the content of ${...} into a provider and a key if it references a registered provider
+*/
+func secretReference(expression string) (string, string, bool) {
+	index := strings.Index(expression, ":")
+	if index <= 0 {
+		return "", "", false
+	}
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	if _, ok := secretProviders[expression[:index]]; !ok {
+		return "", "", false
+	}
+	return expression[:index], expression[index+1:], true
+}
+
+/**
+Replace the ${provider:key} references contained in the value of a variable, anything else is kept as it is
+*/
+func expandSecretReferences(value string) (string, error) {
+	var result strings.Builder
+	for {
+		start := strings.Index(value, "${")
+		if start == -1 {
+			break
+		}
+		end := closingBrace(value, start+2)
+		if end == -1 {
+			break
+		}
+		result.WriteString(value[:start])
+		if name, key, ok := secretReference(value[start+2 : end]); ok {
+			secret, err := resolveSecret(name, key)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(secret)
+		} else {
+			result.WriteString(value[start : end+1])
+		}
+		value = value[end+1:]
+	}
+	result.WriteString(value)
+	return result.String(), nil
+}
+
+/**
+Retrieve the value of a variable resolving the secrets it references, so they are only resolved when used.
+Only trusted variables are resolved, anything else such as registered output, facts or loop items is used
+as literal text so that a server cannot make a secret provider run a local command.
+*/
+func variableValue(name string, variables Variables) (string, bool, error) {
+	variable, defined := variables[variableName(name)]
+	if !defined || !variable.Trusted || !strings.Contains(variable.Value, "${") {
+		return variable.Value, defined, nil
+	}
+	value, err := expandSecretReferences(variable.Value)
+	if err != nil {
+		return "", true, fmt.Errorf("Variable %s: %s", name, err)
+	}
+	return value, true, nil
+}
+
+/**
+Return a copy of the variables with all the secrets they reference resolved, used when all the variables are needed
+*/
+func resolveSecretVariables(variables Variables) (map[string]string, error) {
+	resolved := make(map[string]string, len(variables))
+	for name := range variables {
+		value, _, err := variableValue(name, variables)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = value
+	}
+	return resolved, nil
+}
+
+/**
+fileSecretProvider reads a secret from a local file, ${file:/run/secrets/db}
+*/
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) Resolve(key string) (string, error) {
+	path, _ := homedir.Expand(strings.TrimSpace(key))
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+/**
+commandSecretProvider runs a local command and uses its output as the secret, ${cmd:pass show db}
+*/
+type commandSecretProvider struct{}
+
+func (commandSecretProvider) Resolve(key string) (string, error) {
+	cmd := exec.Command("sh", "-c", key)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
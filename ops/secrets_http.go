@@ -0,0 +1,114 @@
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// time allowed for a request to an HTTP secret store
+const httpSecretTimeout = 10 * time.Second
+
+/**
+HTTPSecretProvider reads secrets from an HTTP store compatible with the HashiCorp Vault KV API.
+The key is the path of the secret relative to the URL followed by #field, ${vault:db#password} requests URL/db
+and returns the password field of the data, the field defaults to value.
+*/
+type HTTPSecretProvider struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (provider *HTTPSecretProvider) Resolve(key string) (string, error) {
+	path, field := key, "value"
+	if index := strings.LastIndex(key, "#"); index != -1 {
+		path, field = key[:index], key[index+1:]
+	}
+
+	request, err := http.NewRequest("GET", strings.TrimRight(provider.URL, "/")+"/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	if provider.Token != "" {
+		request.Header.Set("X-Vault-Token", provider.Token)
+	}
+	client := provider.Client
+	if client == nil {
+		client = &http.Client{Timeout: httpSecretTimeout}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", request.URL, response.Status)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("Invalid response from %s: %s", request.URL, err)
+	}
+	// KV version 2 nests the secret in data.data, version 1 in data
+	for _, data := range []interface{}{nestedField(body, "data", "data"), nestedField(body, "data"), body} {
+		if fields, ok := data.(map[string]interface{}); ok {
+			if value, ok := fields[field]; ok {
+				return fmt.Sprint(value), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Field %s not found in %s", field, request.URL)
+}
+
+/**
+Retrieve a value nested in decoded JSON objects, nil if it does not exist
+*/
+func nestedField(data interface{}, path ...string) interface{} {
+	for _, name := range path {
+		fields, ok := data.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		data = fields[name]
+	}
+	return data
+}
+
+/**
+Register the secret providers defined in the secret_providers block of the config file
+*/
+func (config *Config) RegisterSecretProviders() error {
+	value := config.config.Get("secret_providers")
+	if value == nil {
+		return nil
+	}
+	providers, err := toStringMap(value)
+	if err != nil {
+		return fmt.Errorf("Invalid secret_providers: %s", err)
+	}
+	for name, value := range providers {
+		fields, err := toStringMap(value)
+		if err != nil {
+			return fmt.Errorf("Invalid secret provider %s: %s", name, err)
+		}
+		switch kind := fmt.Sprint(fields["type"]); kind {
+		case "http":
+			url, _ := fields["url"].(string)
+			if url == "" {
+				return fmt.Errorf("Missing url for secret provider %s", name)
+			}
+			token, _ := fields["token"].(string)
+			if tokenEnv, _ := fields["token_env"].(string); tokenEnv != "" {
+				token = os.Getenv(tokenEnv)
+			}
+			RegisterSecretProvider(name, &HTTPSecretProvider{URL: url, Token: token})
+		default:
+			return fmt.Errorf("Unknown type %s for secret provider %s", kind, name)
+		}
+	}
+	return nil
+}
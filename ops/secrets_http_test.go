@@ -0,0 +1,62 @@
+package ops
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSecretProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/db":
+			fmt.Fprint(w, `{"data": {"password": "kv1-password", "value": "kv1-value"}}`)
+		case "/v1/secret/data/db":
+			fmt.Fprint(w, `{"data": {"data": {"password": "kv2-password", "port": 5432}, "metadata": {"version": 3}}}`)
+		case "/v1/secret/flat":
+			fmt.Fprint(w, `{"value": "flat-value"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider := &HTTPSecretProvider{URL: server.URL + "/v1/secret/", Token: "token"}
+	tests := []struct {
+		key   string
+		value string
+		err   string
+	}{
+		{key: "db", value: "kv1-value"},
+		{key: "db#password", value: "kv1-password"},
+		{key: "/db#password", value: "kv1-password"},
+		{key: "data/db#password", value: "kv2-password"},
+		{key: "data/db#port", value: "5432"},
+		{key: "flat", value: "flat-value"},
+		{key: "db#missing", err: "Field missing not found"},
+		{key: "unknown", err: "404 Not Found"},
+	}
+	for _, test := range tests {
+		value, err := provider.Resolve(test.key)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Resolve(%q) returned error %v, expected %q", test.key, err, test.err)
+			}
+			continue
+		}
+		if err != nil || value != test.value {
+			t.Errorf("Resolve(%q) = %q, %v, expected %q", test.key, value, err, test.value)
+		}
+	}
+
+	unauthorized := &HTTPSecretProvider{URL: server.URL + "/v1/secret"}
+	if _, err := unauthorized.Resolve("db"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Resolve without a token returned error %v, expected 403", err)
+	}
+}
@@ -0,0 +1,208 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"strings"
+)
+
+/**
+serviceManager holds the commands used to manage services with an init system, %[1]s is replaced by the service name.
+The active and enabled commands succeed when the service is running and started at boot.
+*/
+type serviceManager struct {
+	active  string
+	enabled string
+	start   string
+	stop    string
+	restart string
+	reload  string
+	enable  string
+	disable string
+}
+
+// init systems supported by the service task and check by the name found in the init_system fact
+var serviceManagers = map[string]serviceManager{
+	"systemd": {
+		active:  "systemctl is-active --quiet %[1]s",
+		enabled: "systemctl is-enabled --quiet %[1]s",
+		start:   "systemctl start %[1]s",
+		stop:    "systemctl stop %[1]s",
+		restart: "systemctl restart %[1]s",
+		reload:  "systemctl reload %[1]s",
+		enable:  "systemctl enable %[1]s",
+		disable: "systemctl disable %[1]s",
+	},
+	"openrc": {
+		active:  "rc-service %[1]s status >/dev/null 2>&1",
+		enabled: "[ -e /etc/runlevels/default/%[1]s ]",
+		start:   "rc-service %[1]s start",
+		stop:    "rc-service %[1]s stop",
+		restart: "rc-service %[1]s restart",
+		reload:  "rc-service %[1]s reload",
+		enable:  "rc-update add %[1]s default",
+		disable: "rc-update del %[1]s default",
+	},
+	"sysvinit": {
+		active:  "service %[1]s status >/dev/null 2>&1",
+		enabled: "ls /etc/rc[2345].d/S??%[1]s >/dev/null 2>&1",
+		start:   "service %[1]s start",
+		stop:    "service %[1]s stop",
+		restart: "service %[1]s restart",
+		reload:  "service %[1]s reload",
+		enable:  "if command -v update-rc.d >/dev/null 2>&1; then update-rc.d %[1]s defaults; else chkconfig %[1]s on; fi",
+		disable: "if command -v update-rc.d >/dev/null 2>&1; then update-rc.d -f %[1]s remove; else chkconfig %[1]s off; fi",
+	},
+}
+
+// detectInitSystemCommand prints the init system of the server when no facts were gathered
+const detectInitSystemCommand = `if [ -d /run/systemd/system ]; then
+	echo systemd
+elif command -v openrc >/dev/null 2>&1 || [ -x /sbin/openrc-run ]; then
+	echo openrc
+else
+	echo sysvinit
+fi`
+
+/**
+Bring the service named in the value of the task to the requested state: started (or running), stopped, restarted
+or reloaded, and start it at boot or not depending on enabled. The init system is found using the init_system fact.
+Starting, stopping, enabling and disabling only happen when needed so the task only reports changed when they do,
+restarted and reloaded always act and a service that is not running is started instead of being reloaded.
+*/
+func ExecuteServiceTask(client *ssh.Client, task *Task, options ssh.ExecuteOptions, variables Variables) (*TaskResult, error) {
+	name := strings.TrimSpace(task.Value)
+	if name == "" {
+		return &TaskResult{}, fmt.Errorf("No service given to the service task")
+	}
+	manager, err := findServiceManager(client, variables)
+	if err != nil {
+		return &TaskResult{}, err
+	}
+
+	var commands []string
+	active, err := client.Test(manager.command(manager.active, name), options)
+	if err != nil {
+		return &TaskResult{}, err
+	}
+	switch state := task.Option("state"); state {
+	case "":
+	case "started", "running":
+		if !active {
+			commands = append(commands, manager.start)
+		}
+	case "stopped":
+		if active {
+			commands = append(commands, manager.stop)
+		}
+	case "restarted":
+		commands = append(commands, manager.restart)
+	case "reloaded":
+		if active {
+			commands = append(commands, manager.reload)
+		} else {
+			commands = append(commands, manager.start)
+		}
+	default:
+		return &TaskResult{}, fmt.Errorf("Invalid state %s for service task, expected started, stopped, restarted or reloaded", state)
+	}
+
+	if task.Option("enabled") != "" {
+		enable, err := task.Bool("enabled")
+		if err != nil {
+			return &TaskResult{}, err
+		}
+		enabled, err := client.Test(manager.command(manager.enabled, name), options)
+		if err != nil {
+			return &TaskResult{}, err
+		}
+		if enable && !enabled {
+			commands = append(commands, manager.enable)
+		} else if !enable && enabled {
+			commands = append(commands, manager.disable)
+		}
+	}
+
+	result := &TaskResult{}
+	for _, command := range commands {
+		output, err := client.ExecuteWithOptions(manager.command(command, name), options)
+		result.Output += output
+		if err != nil {
+			return result, err
+		}
+		result.Changed = true
+	}
+	return result, nil
+}
+
+/**
+Check that a service is in the given state, running or stopped, and that it is started at boot or not
+if enabled is given. Returns the reason the check failed or an empty string if it passed.
+*/
+func CheckService(client *ssh.Client, name string, state string, enabled string, variables Variables) (string, error) {
+	manager, err := findServiceManager(client, variables)
+	if err != nil {
+		return "", err
+	}
+	if state != "" {
+		active, err := client.Test(manager.command(manager.active, name), ssh.ExecuteOptions{})
+		if err != nil {
+			return "", err
+		}
+		switch state {
+		case "running", "started":
+			if !active {
+				return fmt.Sprintf("service %s is not running", name), nil
+			}
+		case "stopped":
+			if active {
+				return fmt.Sprintf("service %s is running", name), nil
+			}
+		default:
+			return "", fmt.Errorf("Invalid state %s for service check, expected running or stopped", state)
+		}
+	}
+	if enabled != "" {
+		enable, err := parseBool(enabled)
+		if err != nil {
+			return "", fmt.Errorf("Invalid enabled for service check: %s", err)
+		}
+		isEnabled, err := client.Test(manager.command(manager.enabled, name), ssh.ExecuteOptions{})
+		if err != nil {
+			return "", err
+		}
+		if enable && !isEnabled {
+			return fmt.Sprintf("service %s is not enabled", name), nil
+		}
+		if !enable && isEnabled {
+			return fmt.Sprintf("service %s is enabled", name), nil
+		}
+	}
+	return "", nil
+}
+
+/**
+Build a command of the service manager for the named service
+*/
+func (manager serviceManager) command(format string, name string) string {
+	return fmt.Sprintf(format, ssh.ShellQuote(name))
+}
+
+/**
+Find the init system of the server from its facts or by looking at the running system
+*/
+func findServiceManager(client *ssh.Client, variables Variables) (serviceManager, error) {
+	name := variables["facts.init_system"].Value
+	if name == "" {
+		output, err := client.Execute(detectInitSystemCommand)
+		if err != nil {
+			return serviceManager{}, fmt.Errorf("Unable to detect the init system: %s", err)
+		}
+		name = strings.TrimSpace(output)
+	}
+	manager, ok := serviceManagers[name]
+	if !ok {
+		return serviceManager{}, fmt.Errorf("Unsupported init system %s", name)
+	}
+	return manager, nil
+}
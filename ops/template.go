@@ -0,0 +1,199 @@
+package ops
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// default permissions of the files created by template tasks
+const defaultTemplateMode = 0644
+
+// templateFuncs contains the helper functions available in templates
+var templateFuncs = template.FuncMap{
+	"default": func(fallback interface{}, value interface{}) interface{} {
+		if value == nil || fmt.Sprint(value) == "" {
+			return fallback
+		}
+		return value
+	},
+	"join": func(separator string, items interface{}) string {
+		return strings.Join(toStringList(items), separator)
+	},
+	"split": func(separator string, value string) []string {
+		return strings.Split(value, separator)
+	},
+	"quote": func(value interface{}) string {
+		return strconv.Quote(fmt.Sprint(value))
+	},
+	"shellquote": func(value interface{}) string {
+		return ssh.ShellQuote(fmt.Sprint(value))
+	},
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"trim":     strings.TrimSpace,
+	"replace":  func(old, new, value string) string { return strings.Replace(value, old, new, -1) },
+	"contains": func(substring, value string) bool { return strings.Contains(value, substring) },
+	"indent": func(spaces int, value string) string {
+		padding := strings.Repeat(" ", spaces)
+		return padding + strings.Replace(value, "\n", "\n"+padding, -1)
+	},
+	"env": os.Getenv,
+}
+
+/**
+Render a local template file and upload the result to the server unless the remote file already has the same contents
+*/
+func ExecuteTemplateOnServer(client *ssh.Client, task *Task, options ssh.ExecuteOptions, variables Variables, strict bool) (*TaskResult, error) {
+	from, to := splitPaths(task.Value)
+	content, err := RenderTemplate(from, variables, strict)
+	if err != nil {
+		return &TaskResult{}, err
+	}
+
+	// compare the checksums to detect if the remote file needs to change
+	checksum := sha256.Sum256(content)
+	remote, err := client.Checksum(to, options)
+	if err != nil {
+		return &TaskResult{}, err
+	}
+	if remote == hex.EncodeToString(checksum[:]) {
+		return &TaskResult{}, nil
+	}
+
+	mode, err := task.FileMode("mode", defaultTemplateMode)
+	if err != nil {
+		return &TaskResult{}, err
+	}
+
+	if !options.Become {
+		err = client.CopyContent(bytes.NewReader(content), int64(len(content)), mode, to)
+		return changedResult("", err)
+	}
+
+	// sudo copies are made from a local file so write the rendered template to a temporary one
+	tmpDir, err := ioutil.TempDir("", "shellbot")
+	if err != nil {
+		return &TaskResult{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpFile := filepath.Join(tmpDir, filepath.Base(to))
+	if err := ioutil.WriteFile(tmpFile, content, mode); err != nil {
+		return &TaskResult{}, err
+	}
+	return changedResult("", client.CopyAs(tmpFile, to, options))
+}
+
+/**
+Render a template file using Go text/template with the variables as data.
+Variable names are lowercase, like in the config file once loaded, so ${APP_DIR} is {{ .app_dir }} in a template.
+Variables with dots in their name are available as nested values, for example {{ .facts.os_family }}.
+Using an undefined variable is an error in strict mode, {{ var "name" }} looks up a variable case insensitively
+and returns an empty string if it is not defined, for example {{ default "80" (var "PORT") }}.
+*/
+func RenderTemplate(file string, variables Variables, strict bool) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read template %s: %s", file, err)
+	}
+	values, err := resolveSecretVariables(variables)
+	if err != nil {
+		return nil, err
+	}
+
+	missingKey := "missingkey=zero"
+	if strict {
+		missingKey = "missingkey=error"
+	}
+	lookup := template.FuncMap{
+		"var": func(name string) string {
+			return values[variableName(name)]
+		},
+	}
+	tmpl, err := template.New(filepath.Base(file)).Funcs(templateFuncs).Funcs(lookup).Option(missingKey).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template %s: %s", file, err)
+	}
+
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, templateData(values)); err != nil {
+		return nil, fmt.Errorf("Unable to render template %s: %s", file, err)
+	}
+	return output.Bytes(), nil
+}
+
+/**
+templateNode holds the fields of a variable whose name is the prefix of other variables, for example ${result}
+and ${result.rc}. The value of the variable itself is kept under the empty key and is printed by {{ .result }}.
+*/
+type templateNode map[string]interface{}
+
+func (node templateNode) String() string {
+	value, _ := node[""].(string)
+	return value
+}
+
+/**
+Convert the variables to the data given to templates, nesting the names that contain dots.
+The full names remain available with the index function, for example {{ index . "result.rc" }}
+*/
+func templateData(variables map[string]string) map[string]interface{} {
+	data := templateNode{}
+	for name, value := range variables {
+		parts := strings.Split(name, ".")
+		if len(parts) > 1 {
+			data[name] = value
+		}
+
+		// a value and the fields sharing its name end up in the same node whatever the order of the names
+		current := data
+		for _, part := range parts[:len(parts)-1] {
+			switch next := current[part].(type) {
+			case templateNode:
+				current = next
+			case string:
+				node := templateNode{"": next}
+				current[part] = node
+				current = node
+			default:
+				node := templateNode{}
+				current[part] = node
+				current = node
+			}
+		}
+		last := parts[len(parts)-1]
+		if node, ok := current[last].(templateNode); ok {
+			node[""] = value
+		} else {
+			current[last] = value
+		}
+	}
+	return data
+}
+
+/**
+Convert a list from a template to a list of strings, a single string is split into words
+*/
+func toStringList(items interface{}) []string {
+	switch list := items.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		result := make([]string, len(list))
+		for i, item := range list {
+			result[i] = fmt.Sprint(item)
+		}
+		return result
+	case string:
+		return strings.Fields(list)
+	}
+	return []string{fmt.Sprint(items)}
+}
@@ -0,0 +1,98 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/ssh"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/**
+Open local, remote and dynamic port forwards through a server and keep them open until interrupted
+*/
+func OpenTunnel(name string, locals []string, remotes []string, dynamics []string, appConfig *Config) error {
+	if len(locals)+len(remotes)+len(dynamics) == 0 {
+		return fmt.Errorf("Specify at least one forwarding rule using -L, -R or -D.")
+	}
+
+	// validate all the rules before connecting to the server
+	localForwards, err := parseForwards(locals)
+	if err != nil {
+		return err
+	}
+	remoteForwards, err := parseForwards(remotes)
+	if err != nil {
+		return err
+	}
+	dynamicForwards := make([]string, len(dynamics))
+	for i, spec := range dynamics {
+		if dynamicForwards[i], err = ssh.ParseDynamicForward(spec); err != nil {
+			return err
+		}
+	}
+
+	// create a new client connected to the server
+	client, err := ConnectToServer(name, appConfig)
+	if err != nil {
+		return err
+	}
+	defer appConfig.CloseConnections()
+
+	var listeners []net.Listener
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	for _, forward := range localForwards {
+		listener, err := client.ForwardLocal(forward)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("Forwarding local %s to %s through %s", forward.ListenAddr, forward.TargetAddr, name))
+	}
+	for _, forward := range remoteForwards {
+		listener, err := client.ForwardRemote(forward)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("Forwarding %s on %s to local %s", forward.ListenAddr, name, forward.TargetAddr))
+	}
+	for _, addr := range dynamicForwards {
+		listener, err := client.ForwardDynamic(addr)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+		logger.Info(fmt.Sprintf("SOCKS5 proxy listening on %s through %s", addr, name))
+	}
+
+	// keep the tunnels open until the user stops the command
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	signal.Stop(interrupt)
+	logger.Info("Closing tunnels to", name)
+	return nil
+}
+
+/**
+Parse a list of forwarding rules given in the ssh -L/-R format
+*/
+func parseForwards(specs []string) ([]ssh.Forward, error) {
+	forwards := make([]ssh.Forward, len(specs))
+	for i, spec := range specs {
+		forward, err := ssh.ParseForward(spec)
+		if err != nil {
+			return nil, err
+		}
+		forwards[i] = forward
+	}
+	return forwards, nil
+}
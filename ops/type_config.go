@@ -2,13 +2,31 @@ package ops
 
 import (
 	"fmt"
+	"github.com/Around25/shellbot/ssh"
 	"github.com/spf13/viper"
-	"os"
+	"strings"
+	"time"
 )
 
 // config contains the contents of the loaded configuration file and provides methods for easily retrieving it's data
 type Config struct {
 	config *viper.Viper
+
+	// connections holds the clients connected to servers so that they are reused by all operations
+	connections *ssh.Pool
+	// executed holds the tasks marked with run_once that were already executed during the run
+	executed map[string]bool
+	// overrides holds the variables given on the command line
+	overrides map[string]string
+	// strict makes undefined variables an error instead of expanding them to an empty string
+	strict bool
+	// facts holds the facts gathered from each server during the run
+	facts map[string]Facts
+	// factsCacheDir and factsCacheTTL configure the on disk cache of the facts
+	factsCacheDir string
+	factsCacheTTL time.Duration
+	// checkAfterSetup verifies the checks of the groups once the tasks of each server are executed
+	checkAfterSetup bool
 }
 
 /**
@@ -16,10 +34,32 @@ Create a new Config object based on a viper config
 */
 func NewConfig(config *viper.Viper) *Config {
 	return &Config{
-		config: config,
+		config:      config,
+		connections: ssh.NewPool(),
+		executed:    map[string]bool{},
+		strict:      true,
+		facts:       map[string]Facts{},
 	}
 }
 
+/**
+Close all the connections opened to servers using this config
+*/
+func (config *Config) CloseConnections() {
+	config.connections.Close()
+}
+
+/**
+Mark a run_once task as executed, returns false if it was already executed during the run
+*/
+func (config *Config) markExecuted(task string) bool {
+	if config.executed[task] {
+		return false
+	}
+	config.executed[task] = true
+	return true
+}
+
 /**
 Retrieve the connection details for a specific server
 */
@@ -39,16 +79,19 @@ func (config *Config) GetGroupsForEnv(env string) []string {
 }
 
 /**
-Retrieve the list of variables for the specified environment from the config file and from the OS
+Retrieve the list of variables for the specified environment from the config file and from the OS,
+without the variables of groups and servers, see ResolveVariables
 */
-func (config *Config) GetVariablesForEnv(env string) map[string]string {
-	variables := config.config.GetStringMapString("environments." + env + ".variables")
-	for key, _ := range variables {
-		if os.Getenv(key) != "" {
-			variables[key] = os.Getenv(key)
-		}
+func (config *Config) GetVariablesForEnv(env string) (map[string]string, error) {
+	resolved, err := config.ResolveVariables(env, "", "")
+	if err != nil {
+		return nil, err
+	}
+	variables := make(map[string]string, len(resolved))
+	for name, variable := range resolved {
+		variables[name] = variable.Value
 	}
-	return variables
+	return variables, nil
 }
 
 /**
@@ -61,48 +104,150 @@ func (config *Config) GetServersForGroup(group string) []string {
 /**
 Retrieve the list of tasks that should be executed for a group
 */
-func (config *Config) GetTasksForGroup(group string) []map[string]string {
+func (config *Config) GetTasksForGroup(group string) ([]*Task, error) {
 	// retrieve the list from the config file
 	tasks := config.config.Get("groups." + group + ".tasks")
 
-	// convert from interface{} to []map[string] string and return
-	return convertFromInterface(tasks)
+	// convert from interface{} to []*Task and return
+	return convertToTasks(tasks)
+}
+
+/**
+Retrieve the options defined for a group that are inherited by all its tasks
+*/
+func (config *Config) GetOptionsForGroup(group string) map[string]interface{} {
+	options := map[string]interface{}{}
+	for _, name := range inheritedOptions {
+		key := "groups." + group + "." + name
+		if config.config.IsSet(key) {
+			options[name] = config.config.Get(key)
+		}
+	}
+	return options
 }
 
 /**
 Retrieve the list of tasks included in a task group
 */
-func (config *Config) GetTasksForTaskGroup(taskGroup string) []map[string]string {
+func (config *Config) GetTasksForTaskGroup(taskGroup string) ([]*Task, error) {
 	// retrieve the list from the config file
 	tasks := config.config.Get("tasks." + taskGroup)
+	if tasks == nil {
+		return nil, fmt.Errorf("Task group is not defined: %s", taskGroup)
+	}
 
-	// convert from interface{} to []map[string] string and return
-	return convertFromInterface(tasks)
+	// task groups that declare parameters contain the list under the tasks key
+	if _, isList := tasks.([]interface{}); !isList {
+		tasks = config.config.Get("tasks." + taskGroup + ".tasks")
+	}
+
+	// convert from interface{} to []*Task and return
+	return convertToTasks(tasks)
+}
+
+/**
+Retrieve the default values of the parameters declared by a task group
+*/
+func (config *Config) GetDefaultsForTaskGroup(taskGroup string) Variables {
+	defaults := Variables{}
+	if _, isList := config.config.Get("tasks." + taskGroup).([]interface{}); !isList {
+		values := map[string]string{}
+		flattenVariables("", config.config.Get("tasks."+taskGroup+".vars"), values)
+		for name, value := range values {
+			defaults[name] = Variable{Value: value, Source: "defaults of task " + taskGroup, Trusted: true}
+		}
+	}
+	return defaults
 }
 
 /**
 Retrieve the list of checks for a specific group
 */
-func (config *Config) GetChecksForGroup(group string) []map[string]string {
+func (config *Config) GetChecksForGroup(group string) ([]map[string]string, error) {
 	checks := config.config.Get("groups." + group + ".checks")
 
 	// convert from interface{} to []map[string] string and return
 	return convertFromInterface(checks)
 }
 
+/**
+Retrieve the handlers available to the tasks of a group by name, the handlers defined in the group override
+the ones defined at the top level. A handler is either a single task or a list of tasks.
+*/
+func (config *Config) GetHandlersForGroup(group string) (map[string][]*Task, error) {
+	handlers := map[string][]*Task{}
+	for _, key := range []string{"handlers", "groups." + group + ".handlers"} {
+		value := config.config.Get(key)
+		if value == nil {
+			continue
+		}
+		definitions, err := toStringMap(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %s", key, err)
+		}
+		for name, definition := range definitions {
+			if _, isList := definition.([]interface{}); !isList {
+				definition = []interface{}{definition}
+			}
+			tasks, err := convertToTasks(definition)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid handler %s: %s", name, err)
+			}
+			// the config keys are case insensitive so the handlers are as well
+			handlers[strings.ToLower(name)] = tasks
+		}
+	}
+	return handlers, nil
+}
+
 /**
 Convert an interface from Viper to an []map[string] string type
 */
-func convertFromInterface(rawData interface{}) []map[string]string {
-	data := rawData.([]interface{})
+func convertFromInterface(rawData interface{}) ([]map[string]string, error) {
+	if rawData == nil {
+		return nil, nil
+	}
+	data, ok := rawData.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Expected a list but got: %v", rawData)
+	}
 	result := make([]map[string]string, len(data))
 	for k, v := range data {
-		val := v.(map[interface{}]interface{})
+		val, err := toStringMap(v)
+		if err != nil {
+			return nil, err
+		}
 		item := make(map[string]string, len(val))
 		for t, d := range val {
-			item[t.(string)] = d.(string)
+			item[t] = fmt.Sprint(d)
 		}
 		result[k] = item
 	}
-	return result
+	return result, nil
+}
+
+/**
+Convert a list of tasks from Viper to a []*Task type
+*/
+func convertToTasks(rawData interface{}) ([]*Task, error) {
+	if rawData == nil {
+		return nil, nil
+	}
+	data, ok := rawData.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Expected a list of tasks but got: %v", rawData)
+	}
+	result := make([]*Task, len(data))
+	for k, v := range data {
+		item, err := toStringMap(v)
+		if err != nil {
+			return nil, err
+		}
+		task, err := NewTask(item)
+		if err != nil {
+			return nil, err
+		}
+		result[k] = task
+	}
+	return result, nil
 }
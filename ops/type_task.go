@@ -0,0 +1,458 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/ssh"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// taskOptions contains the keys of a task entry that change how the task is executed instead of defining its type
+var taskOptions = map[string]bool{
+	"timeout":       true,
+	"retries":       true,
+	"retry_delay":   true,
+	"forward_agent": true,
+	"become":        true,
+	"become_user":   true,
+	"cwd":           true,
+	"env":           true,
+	"interpreter":   true,
+	"expand":        true,
+	"run_once":      true,
+	"mode":          true,
+	"creates":       true,
+	"unless":        true,
+	"only_if":       true,
+	"when":          true,
+	"loop":          true,
+	"with_items":    true,
+	"register":      true,
+	"state":         true,
+	"update_cache":  true,
+	"enabled":       true,
+	"notify":        true,
+}
+
+// rawOptions contains the options that are evaluated when the task runs so their variables are not expanded beforehand
+var rawOptions = map[string]bool{
+	"when":       true,
+	"loop":       true,
+	"with_items": true,
+}
+
+// inheritedOptions contains the options that tasks inherit from their group or from the task group entry running them
+var inheritedOptions = []string{"become", "become_user", "cwd", "env"}
+
+// Task holds a single entry from a list of tasks along with the options used to execute it
+type Task struct {
+	Type  string
+	Value string
+	// Raw holds the value as loaded from the config file for the task types that accept lists or maps
+	Raw     interface{}
+	Options map[string]interface{}
+}
+
+// TaskResult holds the outcome of a task executed on a server
+type TaskResult struct {
+	Output   string
+	ExitCode int
+	Changed  bool
+	// Skipped is set when the task was not executed and SkipReason explains why
+	Skipped    bool
+	SkipReason string
+	// Notified lists the handlers notified by the changed tasks in the order they were notified
+	Notified []string
+}
+
+/**
+Add handlers to the list of notified handlers, each handler is only listed once
+*/
+func (result *TaskResult) Notify(handlers ...string) {
+	for _, handler := range handlers {
+		found := false
+		for _, notified := range result.Notified {
+			if notified == handler {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result.Notified = append(result.Notified, handler)
+		}
+	}
+}
+
+/**
+Create the result of a task that always changes the server when it succeeds
+*/
+func changedResult(output string, err error) (*TaskResult, error) {
+	return &TaskResult{
+		Output:   output,
+		ExitCode: exitCode(err),
+		Changed:  err == nil,
+	}, err
+}
+
+/**
+Retrieve the exit code of a remote or local command from the error returned by it
+*/
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return ssh.ExitStatus(err)
+}
+
+/**
+Store the result of a task in the variables under the given name: the name holds the trimmed output
+while name.stdout, name.rc, name.changed and name.skipped hold the details
+*/
+func registerResult(name string, result *TaskResult, variables Variables) {
+	name = variableName(name)
+	output := strings.TrimSpace(strings.Replace(result.Output, "\r\n", "\n", -1))
+	variables.setValues(map[string]string{
+		name:              output,
+		name + ".stdout":  output,
+		name + ".rc":      strconv.Itoa(result.ExitCode),
+		name + ".changed": strconv.FormatBool(result.Changed),
+		name + ".skipped": strconv.FormatBool(result.Skipped),
+	}, "register")
+}
+
+/**
+Combine the results of the iterations of a loop into a single result
+*/
+func combineResults(results []*TaskResult) *TaskResult {
+	combined := &TaskResult{Skipped: len(results) != 0}
+	var outputs []string
+	for _, result := range results {
+		outputs = append(outputs, result.Output)
+		if result.ExitCode != 0 {
+			combined.ExitCode = result.ExitCode
+		}
+		combined.Changed = combined.Changed || result.Changed
+		combined.Skipped = combined.Skipped && result.Skipped
+		combined.Notify(result.Notified...)
+	}
+	combined.Output = strings.Join(outputs, "\n")
+	return combined
+}
+
+/**
+Create a new Task from an entry of a task list loaded from the config file
+*/
+func NewTask(data map[string]interface{}) (*Task, error) {
+	task := &Task{
+		Options: map[string]interface{}{},
+	}
+	for key, value := range data {
+		if taskOptions[key] {
+			task.Options[key] = value
+			continue
+		}
+		if task.Type != "" {
+			types := []string{task.Type, key}
+			sort.Strings(types)
+			return nil, fmt.Errorf("Task has more than one type: %s", strings.Join(types, ", "))
+		}
+		task.Type = key
+		task.Raw = value
+		task.Value = describeValue(value)
+	}
+	if task.Type == "" {
+		return nil, fmt.Errorf("Task has no type: %v", data)
+	}
+	return task, nil
+}
+
+/**
+Convert the value of a task to the string used by the task types that expect one:
+lists are joined with spaces and maps are represented by their name field
+*/
+func describeValue(value interface{}) string {
+	switch data := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		return strings.Join(toStringList(data), " ")
+	case map[string]interface{}, map[interface{}]interface{}:
+		fields, _ := toStringMap(data)
+		return describeValue(fields["name"])
+	}
+	return fmt.Sprint(value)
+}
+
+/**
+Set the inherited options from the given defaults unless the task defines them itself
+*/
+func (task *Task) Inherit(defaults map[string]interface{}) {
+	for _, name := range inheritedOptions {
+		value, ok := defaults[name]
+		if !ok {
+			continue
+		}
+		current, ok := task.Options[name]
+		if !ok {
+			task.Options[name] = value
+			continue
+		}
+
+		// maps like env are merged with the values of the task taking precedence
+		inherited, err := toStringMap(value)
+		if err != nil {
+			continue
+		}
+		own, err := toStringMap(current)
+		if err != nil {
+			continue
+		}
+		merged := make(map[string]interface{}, len(inherited)+len(own))
+		for key, value := range inherited {
+			merged[key] = value
+		}
+		for key, value := range own {
+			merged[key] = value
+		}
+		task.Options[name] = merged
+	}
+}
+
+/**
+Create a copy of the task with the variables expanded in its value and options, see ExpandVariables for the strict mode
+*/
+func (task *Task) Expand(variables Variables, strict bool) (*Task, error) {
+	value, err := ExpandVariables(task.Value, variables, strict)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := expandValue(task.Raw, variables, strict)
+	if err != nil {
+		return nil, err
+	}
+	expanded := &Task{
+		Type:    task.Type,
+		Value:   value,
+		Raw:     raw,
+		Options: make(map[string]interface{}, len(task.Options)),
+	}
+	for name, value := range task.Options {
+		if rawOptions[name] {
+			expanded.Options[name] = value
+			continue
+		}
+		if expanded.Options[name], err = expandValue(value, variables, strict); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return expanded, nil
+}
+
+/**
+Expand the variables in all the strings contained in a value loaded from the config file
+*/
+func expandValue(value interface{}, variables Variables, strict bool) (interface{}, error) {
+	var err error
+	switch data := value.(type) {
+	case string:
+		return ExpandVariables(data, variables, strict)
+	case expandedValue:
+		return data, nil
+	case []interface{}:
+		result := make([]interface{}, len(data))
+		for i, item := range data {
+			if result[i], err = expandValue(item, variables, strict); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case map[string]interface{}, map[interface{}]interface{}:
+		items, _ := toStringMap(data)
+		result := make(map[string]interface{}, len(items))
+		for key, item := range items {
+			if result[key], err = expandValue(item, variables, strict); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return value, nil
+}
+
+/**
+expandedValue holds a string whose variables were already expanded, such as the options a task group inherits
+from the task running it, so that it is not expanded a second time
+*/
+type expandedValue string
+
+/**
+Mark all the strings contained in an expanded value so that expandValue keeps them as they are
+*/
+func markExpanded(value interface{}) interface{} {
+	switch data := value.(type) {
+	case string:
+		return expandedValue(data)
+	case []interface{}:
+		result := make([]interface{}, len(data))
+		for i, item := range data {
+			result[i] = markExpanded(item)
+		}
+		return result
+	case map[string]interface{}, map[interface{}]interface{}:
+		items, _ := toStringMap(data)
+		result := make(map[string]interface{}, len(items))
+		for key, item := range items {
+			result[key] = markExpanded(item)
+		}
+		return result
+	}
+	return value
+}
+
+/**
+Retrieve the value of an option as a string or an empty string if it is not set
+*/
+func (task *Task) Option(name string) string {
+	value, ok := task.Options[name]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+/**
+Retrieve the value of an option as a duration, plain numbers are treated as seconds
+*/
+func (task *Task) Duration(name string) (time.Duration, error) {
+	value := task.Option(name)
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := parseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s for task %s: %s", name, task.Type, err)
+	}
+	return duration, nil
+}
+
+/**
+Retrieve the value of an option as an integer or 0 if it is not set
+*/
+func (task *Task) Int(name string) (int, error) {
+	value := task.Option(name)
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s for task %s: %s", name, task.Type, err)
+	}
+	return number, nil
+}
+
+/**
+Retrieve the value of an option as a boolean or false if it is not set
+*/
+func (task *Task) Bool(name string) (bool, error) {
+	value := task.Option(name)
+	if value == "" {
+		return false, nil
+	}
+	flag, err := parseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s for task %s: %s", name, task.Type, err)
+	}
+	return flag, nil
+}
+
+/**
+Retrieve the value of an option as a list of strings, a single value is returned as a list with one item
+*/
+func (task *Task) StringList(name string) []string {
+	value, ok := task.Options[name]
+	if !ok || value == nil {
+		return nil
+	}
+	if list, ok := value.([]interface{}); ok {
+		return toStringList(list)
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+/**
+Retrieve the value of an option as a map of strings or nil if it is not set
+*/
+func (task *Task) StringMap(name string) (map[string]string, error) {
+	value, ok := task.Options[name]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	items, err := toStringMap(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s for task %s: %s", name, task.Type, err)
+	}
+	result := make(map[string]string, len(items))
+	for key, item := range items {
+		if item != nil {
+			result[key] = fmt.Sprint(item)
+		} else {
+			result[key] = ""
+		}
+	}
+	return result, nil
+}
+
+/**
+Retrieve the value of an option as file permissions written in octal, YAML already converts numbers like 0644
+*/
+func (task *Task) FileMode(name string, fallback os.FileMode) (os.FileMode, error) {
+	switch value := task.Options[name].(type) {
+	case nil:
+		return fallback, nil
+	case int:
+		return os.FileMode(value), nil
+	}
+	parsed, err := strconv.ParseUint(task.Option(name), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s for task %s: %s", name, task.Type, err)
+	}
+	return os.FileMode(parsed), nil
+}
+
+/**
+Retrieve the items the task should loop over from the loop or with_items option, nil if it has none.
+The option is either a list or the name of a list variable.
+*/
+func (task *Task) LoopItems(variables Variables, strict bool) ([]interface{}, error) {
+	value, ok := task.Options["loop"]
+	if !ok {
+		value, ok = task.Options["with_items"]
+	}
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	switch data := value.(type) {
+	case []interface{}:
+		items, err := expandValue(data, variables, strict)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid loop for task %s: %s", task.Type, err)
+		}
+		return items.([]interface{}), nil
+	case string:
+		name := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(data), "${"), "}")
+		items, ok, err := listVariable(name, variables)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("Loop of task %s uses %s which is not a list variable", task.Type, name)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("Invalid loop for task %s: %v", task.Type, value)
+}
@@ -1,8 +1,10 @@
 package ops
 
 import (
-	"os"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 /**
@@ -30,8 +32,265 @@ func splitPaths(data string) (string, string) {
 	return from, to
 }
 
-func ExpandVariables(data string, variables map[string]string) string {
-	return os.Expand(data, func(found string) string {
-		return variables[found]
-	})
+/**
+Replace the variables in the data using the shell syntax: $NAME, ${NAME}, ${NAME:-default} which uses the
+default when the variable is undefined or empty and ${NAME:?message} which fails with the message in that case.
+${provider:key} is replaced by a secret, see SecretProvider.
+$$ is replaced by a literal $ and a $ that is not followed by a variable name is kept as it is, so $1, $? and $(...)
+reach the shell unchanged. In strict mode undefined variables are an error, otherwise they are replaced by an empty string.
+Variable names are case insensitive.
+*/
+func ExpandVariables(data string, variables Variables, strict bool) (string, error) {
+	if !strings.Contains(data, "$") {
+		return data, nil
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(data); {
+		if data[i] != '$' || i+1 >= len(data) {
+			result.WriteByte(data[i])
+			i++
+			continue
+		}
+
+		next := data[i+1]
+		switch {
+		case next == '$':
+			result.WriteByte('$')
+			i += 2
+		case next == '{':
+			end := closingBrace(data, i+2)
+			if end == -1 {
+				return "", fmt.Errorf("Missing '}' after '%s'", data[i:])
+			}
+			value, err := expandBraces(data[i+2:end], variables, strict)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(value)
+			i = end + 1
+		case isNameStart(next):
+			end := i + 2
+			for end < len(data) && isNamePart(data[end]) {
+				end++
+			}
+			value, err := lookupVariable(data[i+1:end], variables, strict)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(value)
+			i = end
+		default:
+			result.WriteByte('$')
+			i++
+		}
+	}
+	return result.String(), nil
+}
+
+/**
+Expand the content of ${...}: a name optionally followed by :-default or :?message, or a secret as ${provider:key}
+*/
+func expandBraces(expression string, variables Variables, strict bool) (string, error) {
+	name, operator, argument := expression, "", ""
+	if index := strings.Index(expression, ":"); index != -1 && index+1 < len(expression) {
+		switch expression[index+1] {
+		case '-', '?':
+			name, operator, argument = expression[:index], expression[index:index+2], expression[index+2:]
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("Invalid variable '${%s}'", expression)
+	}
+	if operator == "" {
+		if provider, key, ok := secretReference(expression); ok {
+			return resolveSecret(provider, key)
+		}
+	}
+
+	value, defined, err := variableValue(name, variables)
+	if err != nil {
+		return "", err
+	}
+	switch operator {
+	case ":-":
+		if !defined || value == "" {
+			return ExpandVariables(argument, variables, strict)
+		}
+		return value, nil
+	case ":?":
+		if !defined || value == "" {
+			message, err := ExpandVariables(argument, variables, strict)
+			if err != nil {
+				return "", err
+			}
+			if message == "" {
+				message = "undefined or empty"
+			}
+			return "", fmt.Errorf("Variable %s: %s", name, message)
+		}
+		return value, nil
+	}
+	return lookupVariable(name, variables, strict)
+}
+
+/**
+Retrieve the value of a variable, failing in strict mode if it is not defined
+*/
+func lookupVariable(name string, variables Variables, strict bool) (string, error) {
+	value, defined, err := variableValue(name, variables)
+	if err != nil {
+		return "", err
+	}
+	if !defined && strict {
+		return "", fmt.Errorf("Undefined variable %s, use ${%s:-} if it may be empty or $$ for a literal $", name, name)
+	}
+	return value, nil
+}
+
+/**
+Find the position of the } closing a ${ that starts at the given position, skipping nested ${...}
+*/
+func closingBrace(data string, start int) int {
+	depth := 0
+	for i := start; i < len(data); i++ {
+		switch data[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+/**
+Parse a boolean from the config file, yes/no and on/off are accepted as well
+*/
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+/**
+Parse a duration from the config file, plain numbers are treated as seconds
+*/
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+/**
+Convert a map loaded by Viper to a map with string keys
+*/
+func toStringMap(rawData interface{}) (map[string]interface{}, error) {
+	switch data := rawData.(type) {
+	case map[string]interface{}:
+		return data, nil
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(data))
+		for key, value := range data {
+			result[fmt.Sprint(key)] = value
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("Expected a map but got: %v", rawData)
+}
+
+/**
+Flatten a variable loaded from the config file into the variables map.
+Map fields are stored as name.field and list items as name.0, name.1, ... while the name of a list
+holds all its items separated by spaces, for example: apt-get install ${packages}
+*/
+func flattenVariables(name string, value interface{}, variables map[string]string) {
+	name = variableName(name)
+	prefix := name
+	if prefix != "" {
+		prefix += "."
+	}
+
+	switch data := value.(type) {
+	case nil:
+		if name != "" {
+			variables[name] = ""
+		}
+	case []interface{}:
+		items := make([]string, len(data))
+		for i, item := range data {
+			flattenVariables(prefix+strconv.Itoa(i), item, variables)
+			items[i] = fmt.Sprint(item)
+		}
+		variables[name] = strings.Join(items, " ")
+	case map[string]interface{}, map[interface{}]interface{}:
+		fields, _ := toStringMap(data)
+		for key, field := range fields {
+			flattenVariables(prefix+key, field, variables)
+		}
+	default:
+		variables[name] = fmt.Sprint(value)
+	}
+}
+
+/**
+Retrieve the items of a list variable flattened by flattenVariables with the secrets they reference resolved
+*/
+func listVariable(name string, variables Variables) ([]interface{}, bool, error) {
+	name = variableName(name)
+	var items []interface{}
+	for i := 0; ; i++ {
+		itemName := name + "." + strconv.Itoa(i)
+		if value, ok, err := variableValue(itemName, variables); ok || err != nil {
+			if err != nil {
+				return nil, true, err
+			}
+			items = append(items, value)
+			continue
+		}
+
+		// items that are maps only have their fields stored
+		fields := map[string]interface{}{}
+		for key := range variables {
+			if strings.HasPrefix(key, itemName+".") {
+				value, _, err := variableValue(key, variables)
+				if err != nil {
+					return nil, true, err
+				}
+				fields[strings.TrimPrefix(key, itemName+".")] = value
+			}
+		}
+		if len(fields) == 0 {
+			break
+		}
+		items = append(items, fields)
+	}
+	return items, len(items) != 0, nil
+}
+
+/**
+Copy a map of variables so that it can be changed without affecting the original one
+*/
+func copyVariables(variables Variables) Variables {
+	result := make(Variables, len(variables))
+	for key, value := range variables {
+		result[key] = value
+	}
+	return result
 }
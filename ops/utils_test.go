@@ -0,0 +1,117 @@
+package ops
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExpandVariables(t *testing.T) {
+	variables := Variables{
+		"app_dir":    {Value: "/srv/app", Trusted: true},
+		"empty":      {Value: "", Trusted: true},
+		"port":       {Value: "8080", Trusted: true},
+		"packages":   {Value: "nginx git", Trusted: true},
+		"packages.0": {Value: "nginx", Trusted: true},
+		"facts.os":   {Value: "linux"},
+	}
+	tests := []struct {
+		data     string
+		strict   bool
+		expected string
+		err      string
+	}{
+		{data: "no variables", expected: "no variables"},
+		{data: "cd $app_dir", expected: "cd /srv/app"},
+		{data: "cd ${app_dir}/current", expected: "cd /srv/app/current"},
+		{data: "cd ${APP_DIR} $App_Dir", expected: "cd /srv/app /srv/app"},
+		{data: "${packages} ${packages.0} ${facts.os}", expected: "nginx git nginx linux"},
+		{data: "$app_dir.old", expected: "/srv/app.old"},
+
+		// literal dollars reach the shell unchanged
+		{data: "echo $$HOME", strict: true, expected: "echo $HOME"},
+		{data: "price: $$5 $$$$", expected: "price: $5 $$"},
+		{data: "echo $1 $? $# $(pwd) $", strict: true, expected: "echo $1 $? $# $(pwd) $"},
+		{data: "awk '{print $1}'", strict: true, expected: "awk '{print $1}'"},
+
+		// defaults and required values
+		{data: "${missing:-fallback}", strict: true, expected: "fallback"},
+		{data: "${empty:-fallback}", strict: true, expected: "fallback"},
+		{data: "${port:-80}", strict: true, expected: "8080"},
+		{data: "${missing:-}", strict: true, expected: ""},
+		{data: "${missing:-${port}}", strict: true, expected: "8080"},
+		{data: "${missing:-${other:-nested}}", strict: true, expected: "nested"},
+		{data: "${missing:-$$HOME}", strict: true, expected: "$HOME"},
+		{data: "${port:?is required}", strict: true, expected: "8080"},
+		{data: "${missing:?is required}", err: "Variable missing: is required"},
+		{data: "${empty:?}", err: "Variable empty: undefined or empty"},
+		{data: "${missing:?needs ${port}}", err: "Variable missing: needs 8080"},
+
+		// undefined variables
+		{data: "cd $missing/x", expected: "cd /x"},
+		{data: "cd ${missing}", expected: "cd "},
+		{data: "cd $missing", strict: true, err: "Undefined variable missing"},
+		{data: "cd ${missing}", strict: true, err: "Undefined variable missing"},
+		{data: "${missing:-${other}}", strict: true, err: "Undefined variable other"},
+
+		// invalid expressions
+		{data: "cd ${app_dir", err: "Missing '}'"},
+		{data: "cd ${}", err: "Invalid variable"},
+		{data: "${:-x}", err: "Invalid variable"},
+	}
+	for _, test := range tests {
+		result, err := ExpandVariables(test.data, variables, test.strict)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ExpandVariables(%q, strict=%t) = %q, %v, expected error %q", test.data, test.strict, result, err, test.err)
+			}
+			continue
+		}
+		if err != nil || result != test.expected {
+			t.Errorf("ExpandVariables(%q, strict=%t) = %q, %v, expected %q", test.data, test.strict, result, err, test.expected)
+		}
+	}
+}
+
+func TestExpandVariablesSecrets(t *testing.T) {
+	RegisterSecretProvider("test", testSecretProvider{"key": "s3cret"})
+	variables := Variables{
+		"trusted":   {Value: "pass=${test:key}", Trusted: true},
+		"untrusted": {Value: "pass=${test:key}"},
+		"unknown":   {Value: "${other:key}", Trusted: true},
+	}
+	tests := []struct {
+		data     string
+		expected string
+		err      string
+	}{
+		{data: "${test:key}", expected: "s3cret"},
+		{data: "${trusted}", expected: "pass=s3cret"},
+		{data: "${untrusted}", expected: "pass=${test:key}"},
+		{data: "${unknown}", expected: "${other:key}"},
+		{data: "${test:missing}", err: "Unable to resolve ${test:missing}"},
+	}
+	for _, test := range tests {
+		result, err := ExpandVariables(test.data, variables, true)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ExpandVariables(%q) = %q, %v, expected error %q", test.data, result, err, test.err)
+			}
+			continue
+		}
+		if err != nil || result != test.expected {
+			t.Errorf("ExpandVariables(%q) = %q, %v, expected %q", test.data, result, err, test.expected)
+		}
+	}
+}
+
+// testSecretProvider resolves secrets from a map
+type testSecretProvider map[string]string
+
+func (provider testSecretProvider) Resolve(key string) (string, error) {
+	value, ok := provider[key]
+	if !ok {
+		return "", fmt.Errorf("not found")
+	}
+	return value, nil
+}
@@ -0,0 +1,216 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/logger"
+	"github.com/mitchellh/go-homedir"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+/**
+Variable holds the resolved value of a variable and where it was defined
+*/
+type Variable struct {
+	Value  string
+	Source string
+	// Trusted is set for the values written by the user, such as the config file, the only ones
+	// whose ${provider:key} secret references are resolved. Command output, facts and loop items are never trusted.
+	Trusted bool
+}
+
+/**
+Variables holds the variables available to the tasks of a server by lowercase name
+*/
+type Variables map[string]Variable
+
+/**
+Set untrusted variables from their flattened values, their secret references are used as literal text
+*/
+func (variables Variables) setValues(values map[string]string, source string) {
+	for name, value := range values {
+		variables[name] = Variable{Value: value, Source: source}
+	}
+}
+
+/**
+Resolve the variables available to a server of a group in an environment along with their source.
+From lowest to highest precedence the variables are loaded from: the top level variables block (defaults),
+the environment, the env_file of the environment, the group, the server, the OS environment and the --var command line flags.
+The OS environment only overrides variables that are defined in the config file, its names are matched case insensitively.
+All these variables are trusted: the secrets they reference as ${provider:key} are kept as they are and only
+resolved when the variable is used.
+*/
+func (config *Config) ResolveVariables(env string, group string, server string) (Variables, error) {
+	resolved := Variables{}
+	load := func(key string, source string) {
+		values := map[string]string{}
+		flattenVariables("", config.config.Get(key), values)
+		for name, value := range values {
+			resolved[name] = Variable{Value: value, Source: source, Trusted: true}
+		}
+	}
+
+	load("variables", "defaults")
+	if env != "" {
+		load("environments."+env+".variables", "environment "+env)
+
+		if file := config.config.GetString("environments." + env + ".env_file"); file != "" {
+			values, err := readEnvFile(file)
+			if err != nil {
+				return nil, err
+			}
+			for name, value := range values {
+				resolved[variableName(name)] = Variable{Value: value, Source: "env file " + file, Trusted: true}
+			}
+		}
+	}
+	if group != "" {
+		load("groups."+group+".variables", "group "+group)
+	}
+	if server != "" {
+		load("servers."+server+".variables", "server "+server)
+	}
+
+	environment := map[string]string{}
+	for _, entry := range os.Environ() {
+		if parts := strings.SplitN(entry, "=", 2); len(parts) == 2 && parts[1] != "" {
+			environment[variableName(parts[0])] = parts[1]
+		}
+	}
+	for name := range resolved {
+		if value, ok := environment[name]; ok {
+			resolved[name] = Variable{Value: value, Source: "OS environment", Trusted: true}
+		}
+	}
+	for name, value := range config.overrides {
+		resolved[variableName(name)] = Variable{Value: value, Source: "--var", Trusted: true}
+	}
+	return resolved, nil
+}
+
+/**
+Read the variables defined in a .env file as NAME=value lines. Empty lines and comments starting with # are ignored,
+the names may be prefixed with export and the values may be surrounded by single or double quotes.
+*/
+func readEnvFile(file string) (map[string]string, error) {
+	path, _ := homedir.Expand(file)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read env file %s: %s", file, err)
+	}
+
+	values := map[string]string{}
+	for number, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("Invalid line %d in env file %s, expected NAME=value", number+1, file)
+		}
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+/**
+Normalise the name of a variable, Viper lowercases the keys of the config file so the names are stored in lowercase
+and looked up case insensitively: ${APP_DIR} and ${app_dir} are the same variable
+*/
+func variableName(name string) string {
+	return strings.ToLower(name)
+}
+
+/**
+Set the variables given on the command line, they take precedence over any other source
+*/
+func (config *Config) SetOverrides(overrides map[string]string) {
+	config.overrides = overrides
+}
+
+/**
+Set whether undefined variables are an error when expanding tasks, enabled by default
+*/
+func (config *Config) SetStrict(strict bool) {
+	config.strict = strict
+}
+
+/**
+Parse a list of NAME=value variables given on the command line
+*/
+func ParseOverrides(values []string) (map[string]string, error) {
+	overrides := make(map[string]string, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid variable '%s', expected NAME=value", value)
+		}
+		overrides[parts[0]] = parts[1]
+	}
+	return overrides, nil
+}
+
+/**
+Retrieve the groups of the environment that contain the server in the order they are listed
+*/
+func (config *Config) groupsForServer(env string, server string) []string {
+	var groups []string
+	for _, name := range config.GetGroupsForEnv(env) {
+		for _, member := range config.GetServersForGroup(name) {
+			if member == server {
+				groups = append(groups, name)
+				break
+			}
+		}
+	}
+	return groups
+}
+
+/**
+Print the resolved variables of a server in an environment sorted by name along with their source.
+The tasks of every group run with the variables of that group only, so the variables are printed once
+for each group of the environment that contains the server, or only for the given group.
+*/
+func PrintVariables(env string, group string, server string, config *Config) error {
+	if _, err := config.GetServer(server); err != nil {
+		return err
+	}
+	groups := config.groupsForServer(env, server)
+	if group != "" {
+		groups = []string{group}
+	}
+	if len(groups) == 0 {
+		return fmt.Errorf("Server %s is not part of any group of environment %s", server, env)
+	}
+
+	for i, group := range groups {
+		resolved, err := config.ResolveVariables(env, group, server)
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(resolved))
+		for name := range resolved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("# group %s\n", group)
+		for _, name := range names {
+			fmt.Printf("%s=%s\t(%s)\n", name, logger.Mask(resolved[name].Value), resolved[name].Source)
+		}
+	}
+	return nil
+}
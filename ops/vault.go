@@ -0,0 +1,190 @@
+package ops
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/logger"
+	"github.com/Around25/shellbot/vault"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+/**
+Decrypt the values of the config file encrypted with the vault, marked with the vault: prefix or the !vault tag.
+The passphrase is only requested when an encrypted value is found and the decrypted values are masked in the output.
+*/
+func (config *Config) DecryptVault(passphrase func() (string, error)) error {
+	decrypted, changed, err := decryptValue(config.config.AllSettings(), passphrase)
+	if err != nil || !changed {
+		return err
+	}
+	return config.config.MergeConfigMap(decrypted.(map[string]interface{}))
+}
+
+/**
+Decrypt all the encrypted strings contained in a value loaded from the config file
+*/
+func decryptValue(value interface{}, passphrase func() (string, error)) (interface{}, bool, error) {
+	switch data := value.(type) {
+	case string:
+		if !vault.IsEncryptedString(data) {
+			return data, false, nil
+		}
+		key, err := passphrase()
+		if err != nil {
+			return nil, false, err
+		}
+		plain, err := vault.DecryptString(data, key)
+		if err != nil {
+			return nil, false, err
+		}
+		logger.AddSecret(plain)
+		return plain, true, nil
+	case []interface{}:
+		result := make([]interface{}, len(data))
+		changed := false
+		for i, item := range data {
+			decrypted, itemChanged, err := decryptValue(item, passphrase)
+			if err != nil {
+				return nil, false, err
+			}
+			result[i] = decrypted
+			changed = changed || itemChanged
+		}
+		return result, changed, nil
+	case map[string]interface{}, map[interface{}]interface{}:
+		items, _ := toStringMap(data)
+		result := make(map[string]interface{}, len(items))
+		changed := false
+		for key, item := range items {
+			decrypted, itemChanged, err := decryptValue(item, passphrase)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %s", key, err)
+			}
+			result[key] = decrypted
+			changed = changed || itemChanged
+		}
+		return result, changed, nil
+	}
+	return value, false, nil
+}
+
+/**
+Encrypt a file in place with the vault
+*/
+func EncryptFile(file string, passphrase string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %s", file, err)
+	}
+	if vault.IsEncrypted(data) {
+		return fmt.Errorf("%s is already encrypted", file)
+	}
+	encrypted, err := vault.Encrypt(data, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileKeepMode(file, encrypted)
+}
+
+/**
+Decrypt a file encrypted with the vault in place
+*/
+func DecryptFile(file string, passphrase string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %s", file, err)
+	}
+	if !vault.IsEncrypted(data) {
+		return fmt.Errorf("%s is not encrypted", file)
+	}
+	decrypted, err := vault.Decrypt(data, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileKeepMode(file, decrypted)
+}
+
+/**
+Decrypt a file to a temporary file only readable by the current user, open it in $EDITOR and encrypt the result.
+A file that does not exist yet is created.
+*/
+func EditEncryptedFile(file string, passphrase string) error {
+	var data []byte
+	if content, err := ioutil.ReadFile(file); err == nil {
+		if data, err = vault.Decrypt(content, passphrase); err != nil {
+			return fmt.Errorf("Unable to decrypt %s: %s", file, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Unable to read %s: %s", file, err)
+	}
+
+	tmp, err := ioutil.TempFile("", "shellbot-vault-*"+filepath.Ext(file))
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to write temporary file: %s", err)
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", tmp.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Editor %s failed: %s", editor, err)
+	}
+
+	data, err = ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("Unable to read temporary file: %s", err)
+	}
+	encrypted, err := vault.Encrypt(data, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileKeepMode(file, encrypted)
+}
+
+/**
+Read a config file that may be encrypted with the vault, the passphrase is only requested for encrypted files.
+Values tagged with !vault are given the vault: prefix so that DecryptVault decrypts them.
+*/
+func ReadConfigFile(file string, passphrase func() (string, error)) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if vault.IsEncrypted(data) {
+		key, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = vault.Decrypt(data, key); err != nil {
+			return nil, err
+		}
+	}
+	return vault.ReplaceTags(data)
+}
+
+/**
+Replace the content of a file keeping its permissions, new files are only readable by the current user
+*/
+func writeFileKeepMode(file string, data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := ioutil.WriteFile(file, data, mode); err != nil {
+		return fmt.Errorf("Unable to write %s: %s", file, err)
+	}
+	return nil
+}
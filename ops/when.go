@@ -0,0 +1,310 @@
+package ops
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/**
+Evaluate a when expression using the variables as context.
+
+Expressions compare identifiers, which are replaced by the value of the variable with the same name, and literals
+using ==, !=, <, <=, > and >=. Conditions can be combined using &&, ||, ! (or and, or, not) and parentheses.
+Numbers are compared numerically, anything else as strings. A single value is true unless it is empty, 0, false or no.
+Server facts are available as facts.name or by their name alone when no variable has the same name.
+In strict mode undefined identifiers are an error, otherwise they are empty. && and || stop as soon as the result
+is known, so a guard like x != "" && x == "y" does not evaluate the right side when x is empty.
+*/
+func EvaluateCondition(expression string, variables Variables, strict bool) (bool, error) {
+	tokens, err := tokenizeCondition(expression)
+	if err != nil {
+		return false, fmt.Errorf("Invalid when expression '%s': %s", expression, err)
+	}
+	parser := &conditionParser{tokens: tokens, variables: variables, strict: strict}
+	value, err := parser.parseOr()
+	if err == nil && parser.position < len(parser.tokens) {
+		err = fmt.Errorf("unexpected '%s'", parser.tokens[parser.position].text)
+	}
+	if err != nil {
+		return false, fmt.Errorf("Invalid when expression '%s': %s", expression, err)
+	}
+	return value.bool(), nil
+}
+
+// kinds of tokens found in a when expression
+const (
+	tokenIdentifier = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type conditionToken struct {
+	kind int
+	text string
+}
+
+// operators ordered so that the longer ones are matched first
+var conditionOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+// words that can be used instead of the logical operators
+var conditionKeywords = map[string]string{"and": "&&", "or": "||", "not": "!"}
+
+/**
+Split a when expression into tokens
+*/
+func tokenizeCondition(expression string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			// quoted strings support escaping the quote with a backslash
+			var value strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				value.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, conditionToken{tokenString, value.String()})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, conditionToken{tokenNumber, string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			word := string(runes[i:j])
+			if operator, ok := conditionKeywords[word]; ok {
+				tokens = append(tokens, conditionToken{tokenOperator, operator})
+			} else {
+				tokens = append(tokens, conditionToken{tokenIdentifier, word})
+			}
+			i = j
+		default:
+			matched := false
+			for _, operator := range conditionOperators {
+				if strings.HasPrefix(string(runes[i:]), operator) {
+					tokens = append(tokens, conditionToken{tokenOperator, operator})
+					i += len([]rune(operator))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c'", r)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+/**
+conditionValue is the result of evaluating a part of an expression
+*/
+type conditionValue struct {
+	text string
+}
+
+func (value conditionValue) bool() bool {
+	switch strings.ToLower(strings.TrimSpace(value.text)) {
+	case "", "0", "false", "no":
+		return false
+	}
+	return true
+}
+
+func boolValue(value bool) conditionValue {
+	return conditionValue{strconv.FormatBool(value)}
+}
+
+/**
+conditionParser evaluates a list of tokens using recursive descent, from the lowest precedence operator to the highest
+*/
+type conditionParser struct {
+	tokens    []conditionToken
+	position  int
+	variables Variables
+	strict    bool
+	// skipping is set while parsing a side of && or || that does not change the result
+	skipping int
+}
+
+func (parser *conditionParser) peek() (conditionToken, bool) {
+	if parser.position >= len(parser.tokens) {
+		return conditionToken{}, false
+	}
+	return parser.tokens[parser.position], true
+}
+
+func (parser *conditionParser) acceptOperator(operators ...string) (string, bool) {
+	token, ok := parser.peek()
+	if !ok || token.kind != tokenOperator {
+		return "", false
+	}
+	for _, operator := range operators {
+		if token.text == operator {
+			parser.position++
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (parser *conditionParser) parseOr() (conditionValue, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for {
+		if _, ok := parser.acceptOperator("||"); !ok {
+			return left, nil
+		}
+		right, err := parser.parseSkipped(left.bool(), parser.parseAnd)
+		if err != nil {
+			return right, err
+		}
+		left = boolValue(left.bool() || right.bool())
+	}
+}
+
+func (parser *conditionParser) parseAnd() (conditionValue, error) {
+	left, err := parser.parseNot()
+	if err != nil {
+		return left, err
+	}
+	for {
+		if _, ok := parser.acceptOperator("&&"); !ok {
+			return left, nil
+		}
+		right, err := parser.parseSkipped(!left.bool(), parser.parseNot)
+		if err != nil {
+			return right, err
+		}
+		left = boolValue(left.bool() && right.bool())
+	}
+}
+
+/**
+Parse the right side of && or ||, without looking up its identifiers if the left side already decides the result
+*/
+func (parser *conditionParser) parseSkipped(skip bool, parse func() (conditionValue, error)) (conditionValue, error) {
+	if skip {
+		parser.skipping++
+		defer func() { parser.skipping-- }()
+	}
+	return parse()
+}
+
+func (parser *conditionParser) parseNot() (conditionValue, error) {
+	if _, ok := parser.acceptOperator("!"); ok {
+		value, err := parser.parseNot()
+		return boolValue(!value.bool()), err
+	}
+	return parser.parseComparison()
+}
+
+func (parser *conditionParser) parseComparison() (conditionValue, error) {
+	left, err := parser.parseOperand()
+	if err != nil {
+		return left, err
+	}
+	operator, ok := parser.acceptOperator("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := parser.parseOperand()
+	if err != nil {
+		return right, err
+	}
+	return boolValue(compareValues(left.text, operator, right.text)), nil
+}
+
+func (parser *conditionParser) parseOperand() (conditionValue, error) {
+	token, ok := parser.peek()
+	if !ok {
+		return conditionValue{}, fmt.Errorf("unexpected end of expression")
+	}
+	parser.position++
+
+	switch token.kind {
+	case tokenString, tokenNumber:
+		return conditionValue{token.text}, nil
+	case tokenIdentifier:
+		switch token.text {
+		case "true", "false":
+			return conditionValue{token.text}, nil
+		}
+		if parser.skipping > 0 {
+			return conditionValue{}, nil
+		}
+		value, defined, err := variableValue(token.text, parser.variables)
+		if err == nil && !defined {
+			value, defined, err = variableValue("facts."+token.text, parser.variables)
+		}
+		if err == nil && !defined && parser.strict {
+			err = fmt.Errorf("undefined variable %s", token.text)
+		}
+		return conditionValue{value}, err
+	}
+	if token.text == "(" {
+		value, err := parser.parseOr()
+		if err != nil {
+			return value, err
+		}
+		if _, ok := parser.acceptOperator(")"); !ok {
+			return value, fmt.Errorf("missing ')'")
+		}
+		return value, nil
+	}
+	return conditionValue{}, fmt.Errorf("unexpected '%s'", token.text)
+}
+
+/**
+Compare two values numerically if both are numbers and as strings otherwise
+*/
+func compareValues(left string, operator string, right string) bool {
+	result := strings.Compare(left, right)
+	leftNumber, leftErr := strconv.ParseFloat(strings.TrimSpace(left), 64)
+	rightNumber, rightErr := strconv.ParseFloat(strings.TrimSpace(right), 64)
+	if leftErr == nil && rightErr == nil {
+		switch {
+		case leftNumber < rightNumber:
+			result = -1
+		case leftNumber > rightNumber:
+			result = 1
+		default:
+			result = 0
+		}
+	}
+
+	switch operator {
+	case "==":
+		return result == 0
+	case "!=":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	}
+	return result >= 0
+}
@@ -0,0 +1,96 @@
+package ops
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvaluateCondition(t *testing.T) {
+	variables := Variables{
+		"env":             {Value: "production"},
+		"app_dir":         {Value: "/srv/app"},
+		"empty":           {Value: ""},
+		"count":           {Value: "10"},
+		"version":         {Value: "9"},
+		"enabled":         {Value: "yes"},
+		"disabled":        {Value: "false"},
+		"result.rc":       {Value: "0"},
+		"facts.os_family": {Value: "debian"},
+		"facts.memory_mb": {Value: "2048"},
+		"os":              {Value: "variable"},
+		"facts.os":        {Value: "linux"},
+		"quoted":          {Value: `say "hi"`},
+	}
+	tests := []struct {
+		expression string
+		strict     bool
+		expected   bool
+		err        string
+	}{
+		// comparisons
+		{expression: `env == "production"`, expected: true},
+		{expression: `env != 'production'`, expected: false},
+		{expression: `ENV == "production"`, expected: true},
+		{expression: `result.rc == 0`, expected: true},
+		{expression: `count > version`, expected: true},
+		{expression: `count >= 10 && count <= 10`, expected: true},
+		{expression: `count < 9.5`, expected: false},
+		{expression: `"abc" < "abd"`, expected: true},
+		{expression: `-1 < 0`, expected: true},
+		{expression: `quoted == "say \"hi\""`, expected: true},
+
+		// single values
+		{expression: `app_dir`, expected: true},
+		{expression: `empty`, expected: false},
+		{expression: `enabled`, expected: true},
+		{expression: `disabled`, expected: false},
+		{expression: `true`, expected: true},
+		{expression: `false`, expected: false},
+		{expression: `0`, expected: false},
+
+		// logical operators and precedence
+		{expression: `!disabled`, expected: true},
+		{expression: `not enabled`, expected: false},
+		{expression: `enabled and disabled or true`, expected: true},
+		{expression: `enabled && (disabled || false)`, expected: false},
+		{expression: `!(env == "dev") && count > 5`, expected: true},
+
+		// facts by their full name or by their name alone when no variable has the same name
+		{expression: `facts.os_family == "debian"`, strict: true, expected: true},
+		{expression: `os_family == "debian"`, strict: true, expected: true},
+		{expression: `memory_mb >= 1024`, strict: true, expected: true},
+		{expression: `os == "variable"`, strict: true, expected: true},
+
+		// undefined identifiers
+		{expression: `missing == ""`, expected: true},
+		{expression: `missing == ""`, strict: true, err: "undefined variable missing"},
+		{expression: `!missing`, strict: true, err: "undefined variable missing"},
+
+		// && and || do not evaluate the side that does not change the result
+		{expression: `empty != "" && missing == "x"`, strict: true, expected: false},
+		{expression: `app_dir != "" || missing == "x"`, strict: true, expected: true},
+		{expression: `disabled && (missing || other)`, strict: true, expected: false},
+		{expression: `enabled && missing == "x"`, strict: true, err: "undefined variable missing"},
+		{expression: `disabled || missing == "x"`, strict: true, err: "undefined variable missing"},
+
+		// invalid expressions
+		{expression: `env ==`, err: "unexpected end of expression"},
+		{expression: `(env == "production"`, err: "missing ')'"},
+		{expression: `env == "production")`, err: "unexpected ')'"},
+		{expression: `env == "production`, err: "unterminated string"},
+		{expression: `env = "production"`, err: "unexpected character '='"},
+		{expression: `env production`, err: "unexpected 'production'"},
+	}
+	for _, test := range tests {
+		result, err := EvaluateCondition(test.expression, variables, test.strict)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("EvaluateCondition(%q, strict=%t) = %t, %v, expected error %q", test.expression, test.strict, result, err, test.err)
+			}
+			continue
+		}
+		if err != nil || result != test.expected {
+			t.Errorf("EvaluateCondition(%q, strict=%t) = %t, %v, expected %t", test.expression, test.strict, result, err, test.expected)
+		}
+	}
+}
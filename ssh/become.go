@@ -0,0 +1,122 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+)
+
+// sudoPrompt is the prompt sudo is asked to print so it can be recognized in the output and answered
+const sudoPrompt = "[shellbot-sudo-password]"
+
+/**
+becomeCommand wraps a command so that it is executed through sudo as the given user, root if empty
+*/
+func becomeCommand(command string, user string) string {
+	sudo := "sudo -S -p " + ShellQuote(sudoPrompt)
+	if user != "" {
+		sudo += " -u " + ShellQuote(user)
+	}
+	return sudo + " -- sh -c " + ShellQuote(command)
+}
+
+/**
+  CopyAs copies a file or directory to the server through a temporary location
+  and then moves it to the destination using sudo
+*/
+func (client *Client) CopyAs(srcPath, destPath string, options ExecuteOptions) error {
+	tmpDir, err := client.TempDir()
+	if err != nil {
+		return err
+	}
+	defer client.RemoveAll(tmpDir)
+
+	// upload the data to the temporary directory as the connected user
+	tmpPath := path.Join(tmpDir, path.Base(srcPath))
+	if err := client.Copy(srcPath, tmpPath); err != nil {
+		return err
+	}
+
+	if err := client.shareWithBecomeUser(tmpDir, options); err != nil {
+		return err
+	}
+
+	output, err := client.ExecuteWithOptions(fmt.Sprintf("cp -R %s %s", ShellQuote(tmpPath), ShellQuote(destPath)), options)
+	if err != nil {
+		return fmt.Errorf("Unable to move %s to %s: %s %s", srcPath, destPath, output, err)
+	}
+	return nil
+}
+
+/**
+  shareWithBecomeUser makes the contents of a temporary directory readable when commands run as another user than root
+*/
+func (client *Client) shareWithBecomeUser(tmpDir string, options ExecuteOptions) error {
+	if !options.Become || options.BecomeUser == "" || options.BecomeUser == "root" {
+		return nil
+	}
+	if output, err := client.Execute("chmod -R a+rX " + ShellQuote(tmpDir)); err != nil {
+		return fmt.Errorf("Unable to share temporary directory: %s %s", output, err)
+	}
+	return nil
+}
+
+/**
+  sudoResponder forwards the output of a command and answers the sudo password prompts found in it
+*/
+type sudoResponder struct {
+	mutex    sync.Mutex
+	output   io.Writer
+	stdin    io.Writer
+	password func() (string, error)
+	pending  []byte
+	err      error
+}
+
+func (responder *sudoResponder) Write(data []byte) (int, error) {
+	responder.mutex.Lock()
+	defer responder.mutex.Unlock()
+
+	responder.pending = append(responder.pending, data...)
+	for {
+		index := bytes.Index(responder.pending, []byte(sudoPrompt))
+		if index == -1 {
+			break
+		}
+		// remove the prompt from the output and answer it
+		responder.output.Write(responder.pending[:index])
+		responder.pending = responder.pending[index+len(sudoPrompt):]
+
+		password, err := responder.password()
+		if err != nil {
+			responder.err = err
+			password = ""
+		}
+		io.WriteString(responder.stdin, password+"\n")
+	}
+
+	// keep the end of the output if it could be the start of a prompt
+	keep := 0
+	for size := len(sudoPrompt) - 1; size > 0; size-- {
+		if bytes.HasSuffix(responder.pending, []byte(sudoPrompt[:size])) {
+			keep = size
+			break
+		}
+	}
+	responder.output.Write(responder.pending[:len(responder.pending)-keep])
+	responder.pending = responder.pending[len(responder.pending)-keep:]
+	return len(data), nil
+}
+
+/**
+  Flush writes the remaining output and returns the error raised while loading the password, if any
+*/
+func (responder *sudoResponder) Flush() error {
+	responder.mutex.Lock()
+	defer responder.mutex.Unlock()
+	responder.output.Write(responder.pending)
+	responder.pending = nil
+	return responder.err
+}
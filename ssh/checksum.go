@@ -0,0 +1,24 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+/**
+  Checksum returns the SHA-256 checksum of a file on the server or an empty string if the file does not exist
+*/
+func (client *Client) Checksum(path string, options ExecuteOptions) (string, error) {
+	quoted := ShellQuote(path)
+	command := fmt.Sprintf("if [ -f %s ]; then sha256sum %s; fi", quoted, quoted)
+	output, err := client.ExecuteWithOptions(command, options)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read checksum of %s: %s %s", path, output, err)
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
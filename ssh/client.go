@@ -2,9 +2,14 @@ package ssh
 
 import (
 	"fmt"
+	"github.com/Around25/shellbot/logger"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"io"
+	"net"
 	"os"
+	"sync"
+	"time"
 )
 
 /**
@@ -13,6 +18,15 @@ Client holds an active connection to a SSH server
 type Client struct {
 	Config *Config
 	conn   *ssh.Client
+	done   chan struct{}
+
+	// agentForwarding is set once the local agent is registered as handler for forwarding requests on conn
+	agentForwarding bool
+
+	// mutex guards the connection properties so that the client can be shared between goroutines
+	mutex sync.Mutex
+	// sessions limits the number of sessions open at the same time on the connection
+	sessions chan struct{}
 }
 
 /**
@@ -22,6 +36,9 @@ func New(config *Config) (client *Client) {
 	client = &Client{
 		Config: config,
 	}
+	if config.MaxSessions > 0 {
+		client.sessions = make(chan struct{}, config.MaxSessions)
+	}
 	return client
 }
 
@@ -29,21 +46,180 @@ func New(config *Config) (client *Client) {
 Connect the client to the configured server
 */
 func (client *Client) Connect() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.connect()
+}
+
+/**
+Disconnect closes the active connection and stops sending keepalive requests
+*/
+func (client *Client) Disconnect() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.disconnect()
+}
+
+/**
+Reconnect closes the current connection, if any, and dials the server again
+*/
+func (client *Client) Reconnect() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.disconnect()
+	return client.connect()
+}
+
+/**
+connection returns the active connection, dialing the server first if there is none
+*/
+func (client *Client) connection() (*ssh.Client, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.conn == nil {
+		if err := client.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return client.conn, nil
+}
+
+/**
+reconnect replaces a broken connection with a new one unless another goroutine has already done it
+*/
+func (client *Client) reconnect(broken *ssh.Client) (*ssh.Client, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.conn != nil && client.conn != broken {
+		return client.conn, nil
+	}
+	client.disconnect()
+	if err := client.connect(); err != nil {
+		return nil, err
+	}
+	return client.conn, nil
+}
+
+/**
+acquireSession blocks until a new session can be opened without going over the configured limit
+*/
+func (client *Client) acquireSession() {
+	if client.sessions != nil {
+		client.sessions <- struct{}{}
+	}
+}
+
+/**
+releaseSession frees the slot taken by acquireSession
+*/
+func (client *Client) releaseSession() {
+	if client.sessions != nil {
+		<-client.sessions
+	}
+}
+
+/**
+connect dials the server, the caller must hold the mutex
+*/
+func (client *Client) connect() error {
 	config, err := client.Config.GetAuthConfig()
 	if err != nil {
 		return err
 	}
-	connection, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", client.Config.Host, client.Config.Port), config)
+	connection, err := dial(fmt.Sprintf("%s:%d", client.Config.Host, client.Config.Port), config)
 	if err != nil {
 		return fmt.Errorf("Failed to dial: %s", err)
 	}
 	client.conn = connection
+	client.agentForwarding = false
+
+	// detect dead connections by sending keepalive requests in the background
+	if client.Config.KeepAliveInterval > 0 {
+		client.done = make(chan struct{})
+		go keepAlive(connection, client.Config.KeepAliveInterval, client.Config.KeepAliveCountMax, client.done)
+	}
 	return nil
 }
 
-func (client *Client) Disconnect() {
+/**
+disconnect closes the active connection, the caller must hold the mutex
+*/
+func (client *Client) disconnect() {
+	if client.done != nil {
+		close(client.done)
+		client.done = nil
+	}
 	if client.conn != nil {
 		client.conn.Close()
+		client.conn = nil
+	}
+}
+
+/**
+dial opens a TCP connection to the address and performs the SSH handshake within the configured timeout
+*/
+func dial(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// the dial timeout does not cover the handshake so limit it using a deadline on the connection
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+/**
+keepAlive periodically sends keepalive@openssh.com requests and closes the connection
+when the server fails to answer countMax requests in a row
+*/
+func keepAlive(conn *ssh.Client, interval time.Duration, countMax int, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		// a dead connection may never answer so don't wait longer than the interval
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		var err error
+		select {
+		case err = <-reply:
+		case <-time.After(interval):
+			err = fmt.Errorf("no reply received in %s", interval)
+		case <-done:
+			return
+		}
+
+		if err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		if failures >= countMax {
+			logger.Warning("Connection to", conn.RemoteAddr(), "is dead:", err)
+			conn.Close()
+			return
+		}
 	}
 }
 
@@ -51,19 +227,39 @@ func (client *Client) Disconnect() {
   Connect to the server base on a client auth config
 */
 func (client *Client) StartSession(bindIOStreams bool, createPty bool) (*ssh.Session, error) {
+	return client.startSession(bindIOStreams, createPty, client.Config.ForwardAgent)
+}
+
+/**
+  startSession opens a new session and optionally forwards the local SSH agent to it
+*/
+func (client *Client) startSession(bindIOStreams bool, createPty bool, forwardAgent bool) (*ssh.Session, error) {
 	// make sure the connection is available
-	if client.conn == nil {
-		err := client.Connect()
-		if err != nil {
-			return nil, err
-		}
+	conn, err := client.connection()
+	if err != nil {
+		return nil, err
 	}
 	// start a new SSH session
-	session, err := client.conn.NewSession()
+	session, err := conn.NewSession()
+	if _, refused := err.(*ssh.OpenChannelError); err != nil && !refused {
+		// the connection might be gone so dial again and retry once, a refused session leaves it usable
+		if conn, err = client.reconnect(conn); err != nil {
+			return nil, fmt.Errorf("Failed to create session: %s", err)
+		}
+		session, err = conn.NewSession()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create session: %s", err)
 	}
 
+	// forward the local agent so that commands on the server can use its keys
+	if forwardAgent {
+		if err := client.forwardAgent(conn, session); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+
 	// bind IO streams
 	if bindIOStreams {
 		session.Stdout = os.Stdout
@@ -88,6 +284,40 @@ func (client *Client) StartSession(bindIOStreams bool, createPty bool) (*ssh.Ses
 	return session, nil
 }
 
+/**
+  forwardAgent requests agent forwarding for the session, registering the local agent for the connection when needed
+*/
+func (client *Client) forwardAgent(conn *ssh.Client, session *ssh.Session) error {
+	if err := client.registerAgent(conn); err != nil {
+		return err
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return fmt.Errorf("Agent forwarding request failed: %s", err)
+	}
+	return nil
+}
+
+/**
+  registerAgent registers the local agent as handler for forwarding requests once per connection
+*/
+func (client *Client) registerAgent(conn *ssh.Client) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.agentForwarding || client.conn != conn {
+		return nil
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return fmt.Errorf("Unable to forward the SSH agent: SSH_AUTH_SOCK is not set")
+	}
+	if err := agent.ForwardToRemote(conn, socket); err != nil {
+		return fmt.Errorf("Unable to forward the SSH agent: %s", err)
+	}
+	client.agentForwarding = true
+	return nil
+}
+
 /**
 Try the connection to the server
 */
@@ -1,15 +1,28 @@
 package ssh
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/Around25/shellbot/logger"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
 	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
+	"os/exec"
 	"strconv"
 	"strings"
+	"time"
+)
+
+// default values used for the connection properties when none are configured
+const (
+	DefaultConnectTimeout    = 30 * time.Second
+	DefaultKeepAliveInterval = 30 * time.Second
+	DefaultKeepAliveCountMax = 3
+	DefaultMaxSessions       = 10
 )
 
 // Config holds the configuration properties for one server connection
@@ -18,11 +31,37 @@ type Config struct {
 	Host string
 	Port int
 
+	// ConnectTimeout limits the time spent dialing and authenticating, 0 disables it
+	ConnectTimeout time.Duration
+	// KeepAliveInterval is the time between two keepalive requests, 0 disables them
+	KeepAliveInterval time.Duration
+	// KeepAliveCountMax is the number of unanswered keepalive requests after which the connection is closed
+	KeepAliveCountMax int
+	// MaxSessions limits the number of sessions open at the same time on the connection, 0 disables the limit
+	MaxSessions int
+
 	// authentication properties
 	User     string
 	Password string
 	AuthFile string
 	SSHAgent bool
+	// PasswordEnv is the name of an environment variable holding the password
+	PasswordEnv string
+	// PasswordCommand is a local command that prints the password
+	PasswordCommand string
+	// CertFile is an OpenSSH user certificate signed for the key in AuthFile
+	CertFile string
+
+	// privilege escalation properties, the sudo password or the environment variable holding it
+	BecomePassword    string
+	BecomePasswordEnv string
+
+	// host verification properties, the host key is not verified when none are set
+	KnownHostsFile string
+	HostCAFile     string
+
+	// ForwardAgent forwards the local SSH agent to every session opened on the server
+	ForwardAgent bool
 
 	// session properties
 	CreatePty     bool
@@ -73,14 +112,18 @@ func NewConfig(uri string, authFile string, sshAgent bool, createPty bool, bindI
 
 	// return the filled config object
 	return &Config{
-		Host:          host,
-		User:          user,
-		Password:      pass,
-		Port:          iPort,
-		AuthFile:      authFile,
-		SSHAgent:      sshAgent,
-		CreatePty:     createPty,
-		BindIOStreams: bindIoStreams,
+		Host:              host,
+		User:              user,
+		Password:          pass,
+		Port:              iPort,
+		ConnectTimeout:    DefaultConnectTimeout,
+		KeepAliveInterval: DefaultKeepAliveInterval,
+		KeepAliveCountMax: DefaultKeepAliveCountMax,
+		MaxSessions:       DefaultMaxSessions,
+		AuthFile:          authFile,
+		SSHAgent:          sshAgent,
+		CreatePty:         createPty,
+		BindIOStreams:     bindIoStreams,
 	}
 }
 
@@ -88,22 +131,140 @@ func NewConfig(uri string, authFile string, sshAgent bool, createPty bool, bindI
   GetAuthConfig loads an authentication configuration based on the user and auth method provided
 */
 func (config *Config) GetAuthConfig() (*ssh.ClientConfig, error) {
-	var auth ssh.AuthMethod = nil
-	if len(config.Password) != 0 {
-		auth = config.AuthViaPassword(config.Password)
+	var auth []ssh.AuthMethod
+
+	// keys are tried first, then passwords
+	if len(config.AuthFile) != 0 && len(config.CertFile) != 0 {
+		method, err := config.AuthViaCertificate(config.AuthFile, config.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, method)
 	} else if len(config.AuthFile) != 0 {
-		auth = config.AuthViaKey(config.AuthFile)
-	} else if config.SSHAgent {
-		auth = config.AuthViaSSHAgent()
+		if method := config.AuthViaKey(config.AuthFile); method != nil {
+			auth = append(auth, method)
+		}
+	}
+	if config.SSHAgent {
+		if method := config.AuthViaSSHAgent(); method != nil {
+			auth = append(auth, method)
+		}
+	}
+	auth = append(auth, ssh.PasswordCallback(config.password), config.AuthViaKeyboardInteractive())
+
+	hostKeyCallback, err := config.HostKeyCallback()
+	if err != nil {
+		return nil, err
 	}
 
 	config.Config = &ssh.ClientConfig{
-		User: config.User,
-		Auth: []ssh.AuthMethod{auth},
+		User:            config.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         config.ConnectTimeout,
 	}
 	return config.Config, nil
 }
 
+/**
+  password loads the password from the uri, the environment or the password command
+  and prompts the user for it if none is configured
+*/
+func (config *Config) password() (string, error) {
+	if len(config.Password) != 0 {
+		return config.Password, nil
+	}
+
+	var err error
+	if len(config.PasswordEnv) != 0 {
+		config.Password = os.Getenv(config.PasswordEnv)
+		if len(config.Password) == 0 {
+			return "", fmt.Errorf("Environment variable %s holding the password is empty", config.PasswordEnv)
+		}
+	} else if len(config.PasswordCommand) != 0 {
+		config.Password, err = runPasswordCommand(config.PasswordCommand)
+	} else {
+		config.Password, err = Prompt(fmt.Sprintf("%s@%s's password: ", config.User, config.Host), false)
+	}
+	return config.Password, err
+}
+
+/**
+  becomePassword loads the sudo password from the config or the environment
+  and prompts the user for it if none is configured
+*/
+func (config *Config) becomePassword() (string, error) {
+	if len(config.BecomePassword) != 0 {
+		return config.BecomePassword, nil
+	}
+
+	var err error
+	if len(config.BecomePasswordEnv) != 0 {
+		config.BecomePassword = os.Getenv(config.BecomePasswordEnv)
+		if len(config.BecomePassword) == 0 {
+			return "", fmt.Errorf("Environment variable %s holding the sudo password is empty", config.BecomePasswordEnv)
+		}
+	} else {
+		config.BecomePassword, err = Prompt(fmt.Sprintf("[sudo] password for %s@%s: ", config.User, config.Host), false)
+	}
+	return config.BecomePassword, err
+}
+
+/**
+  runPasswordCommand executes a local command and returns the first line of its output
+*/
+func runPasswordCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Password command failed: %s", err)
+	}
+	return strings.SplitN(strings.TrimRight(string(output), "\r\n"), "\n", 2)[0], nil
+}
+
+/**
+  HostKeyCallback builds the verification of the host key based on the known_hosts file and the host CA configured.
+  When only a host CA is configured the server must present a certificate signed by it.
+*/
+func (config *Config) HostKeyCallback() (ssh.HostKeyCallback, error) {
+	// plain host keys are checked against known_hosts, which also handles @cert-authority entries
+	fallback := ssh.InsecureIgnoreHostKey()
+	if len(config.HostCAFile) != 0 {
+		fallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return fmt.Errorf("Host key of %s is not a certificate signed by the host CA in %s", hostname, config.HostCAFile)
+		}
+	}
+	if len(config.KnownHostsFile) != 0 {
+		callback, err := knownhosts.New(config.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load known hosts file %s: %s", config.KnownHostsFile, err)
+		}
+		fallback = callback
+	}
+	if len(config.HostCAFile) == 0 {
+		return fallback, nil
+	}
+
+	// host certificates must be signed by the configured CA
+	buffer, err := ioutil.ReadFile(config.HostCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read host CA file %s: %s", config.HostCAFile, err)
+	}
+	authority, _, _, _, err := ssh.ParseAuthorizedKey(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid host CA public key in %s: %s", config.HostCAFile, err)
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return bytes.Equal(auth.Marshal(), authority.Marshal())
+		},
+		HostKeyFallback: fallback,
+	}
+	return checker.CheckHostKey, nil
+}
+
 /**
   AuthViaPassword returns an authentication method using a password as a credential
 */
@@ -111,6 +272,33 @@ func (config *Config) AuthViaPassword(pass string) ssh.AuthMethod {
 	return ssh.Password(pass)
 }
 
+/**
+  AuthViaKeyboardInteractive returns an authentication method that answers the server questions
+  with the configured password or by prompting the user on the terminal
+*/
+func (config *Config) AuthViaKeyboardInteractive() ssh.AuthMethod {
+	return ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		// most servers only ask for the password
+		if len(questions) == 1 && !echos[0] && (len(config.Password) != 0 || len(config.PasswordEnv) != 0 || len(config.PasswordCommand) != 0) {
+			password, err := config.password()
+			return []string{password}, err
+		}
+
+		if len(instruction) != 0 {
+			fmt.Fprintln(os.Stderr, instruction)
+		}
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			answer, err := Prompt(question, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	})
+}
+
 /**
   AuthViaKey returns an authentication method using a private credential file
 */
@@ -127,6 +315,39 @@ func (config *Config) AuthViaKey(file string) ssh.AuthMethod {
 	return ssh.PublicKeys(key)
 }
 
+/**
+  AuthViaCertificate returns an authentication method using a private key and the OpenSSH certificate issued for it
+*/
+func (config *Config) AuthViaCertificate(keyFile string, certFile string) (ssh.AuthMethod, error) {
+	buffer, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read key file %s: %s", keyFile, err)
+	}
+	key, err := ssh.ParsePrivateKey(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid private key in %s: %s", keyFile, err)
+	}
+
+	buffer, err = ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read certificate file %s: %s", certFile, err)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid certificate in %s: %s", certFile, err)
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain a certificate", certFile)
+	}
+
+	signer, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to use certificate %s: %s", certFile, err)
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
 /**
   AuthViaSSHAgent returns an authentication method using an SSH Agent with loaded keys
 */
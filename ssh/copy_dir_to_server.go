@@ -11,6 +11,9 @@ import (
 Copy a directory from the source to the destination
 */
 func (client *Client) CopyDir(srcPath, destination string) error {
+	client.acquireSession()
+	defer client.releaseSession()
+
 	// start SSH connection
 	session, err := client.StartSession(false, false)
 	if err != nil {
@@ -34,6 +34,9 @@ func (client *Client) Copy(srcPath, destPath string) error {
 Copy a file from the source to the destination
 */
 func (client *Client) CopyFile(srcPath, destination string) error {
+	client.acquireSession()
+	defer client.releaseSession()
+
 	// start SSH connection
 	session, err := client.StartSession(false, false)
 	if err != nil {
@@ -76,6 +79,46 @@ func (client *Client) CopyFile(srcPath, destination string) error {
 	return nil
 }
 
+/**
+Copy the contents of a reader to a file on the server with the given permissions
+*/
+func (client *Client) CopyContent(src io.Reader, size int64, mode os.FileMode, destination string) error {
+	client.acquireSession()
+	defer client.releaseSession()
+
+	// start SSH connection
+	session, err := client.StartSession(false, false)
+	if err != nil {
+		return fmt.Errorf("Unable to contact server[%s]: %s", client.Config.Host, err)
+	}
+	defer session.Close()
+
+	// open an input stream to the server
+	dest, _ := session.StdinPipe()
+	defer func() {
+		if dest != nil {
+			dest.Close()
+		}
+	}()
+
+	// start receiving the file on the server using scp but don't wait for the command to finish
+	cmd := fmt.Sprintf("scp -vt %s", filepath.ToSlash(path.Dir(destination)))
+	if err := session.Start(cmd); err != nil {
+		session.Close()
+		return err
+	}
+
+	// send the contents over the wire
+	if err = scpTransferFile(path.Base(destination), mode, size, src, dest); err != nil {
+		return err
+	}
+	dest.Close()
+	dest = nil
+
+	// wait until the command has finished and see if there are any errors
+	return session.Wait()
+}
+
 /**
 Open a file and transfer it to the destination
 */
@@ -15,6 +15,9 @@ import (
 Download a file or directory from the server
 */
 func (client *Client) Download(srcPath, destination string) error {
+	client.acquireSession()
+	defer client.releaseSession()
+
 	// start SSH connection
 	session, err := client.StartSession(false, false)
 	if err != nil {
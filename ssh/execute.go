@@ -1,24 +1,214 @@
 package ssh
 
 import (
+	"bytes"
 	"fmt"
+	"golang.org/x/crypto/ssh"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// envNamePattern matches the names that can be used for environment variables in a shell
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// time given to a command to exit after receiving the termination signal before the session is closed
+const terminateGracePeriod = 5 * time.Second
+
+/**
+ExecuteOptions holds the optional settings used when executing a command
+*/
+type ExecuteOptions struct {
+	// Timeout stops the command if it runs for longer than the given duration, 0 disables it
+	Timeout time.Duration
+	// ForwardAgent forwards the local SSH agent to the command even if it is not enabled for the server
+	ForwardAgent bool
+	// Become executes the command through sudo as BecomeUser, or root if no user is given
+	Become     bool
+	BecomeUser string
+	// Cwd is the directory in which the command is executed
+	Cwd string
+	// Env holds the environment variables set for the command
+	Env map[string]string
+}
+
 /**
   Execute a command on the server
 */
 func (client *Client) Execute(command string) (string, error) {
-	session, err := client.StartSession(false, true)
+	return client.ExecuteWithOptions(command, ExecuteOptions{})
+}
+
+/**
+  ExecuteWithOptions executes a command on the server using the given options
+*/
+func (client *Client) ExecuteWithOptions(command string, options ExecuteOptions) (string, error) {
+	client.acquireSession()
+	defer client.releaseSession()
+
+	session, err := client.startSession(false, true, options.ForwardAgent || client.Config.ForwardAgent)
 	if err != nil {
 		return "", fmt.Errorf("Unable to contact server[%s]: %s", client.Config.Host, err)
 	}
 	defer session.Close()
 
-	//	session.Run(command) // without capturing the output, just the error; the output can be banded to a io.Writer
-	output, err := session.CombinedOutput(command)
+	output, responder, err := client.startCommand(session, command, options)
 	if err != nil {
-		return string(output), err
+		return "", err
+	}
+
+	// wait for the command to finish in the background so that it can be stopped on timeout
+	result := make(chan error, 1)
+	go func() {
+		result <- session.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if options.Timeout > 0 {
+		timer := time.NewTimer(options.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err = <-result:
+	case <-timeout:
+		// ask the command to stop and close the session if it doesn't
+		session.Signal(ssh.SIGTERM)
+		select {
+		case <-result:
+		case <-time.After(terminateGracePeriod):
+		}
+		session.Close()
+		err = fmt.Errorf("Command timed out after %s: %s", options.Timeout, command)
+	}
+
+	if responder != nil {
+		if passwordErr := responder.Flush(); passwordErr != nil && err != nil {
+			err = fmt.Errorf("%s (sudo password: %s)", err, passwordErr)
+		}
+	}
+	return output.String(), err
+}
+
+/**
+  startCommand starts a command on the session using the given options. Both output streams are captured in the
+  returned buffer and, with become, the returned responder answers the sudo password prompt.
+*/
+func (client *Client) startCommand(session *ssh.Session, command string, options ExecuteOptions) (*outputBuffer, *sudoResponder, error) {
+	// capture both output streams in the same buffer just like session.CombinedOutput does
+	output := &outputBuffer{}
+	session.Stdout = output
+	session.Stderr = output
+
+	command, err := prepareCommand(session, command, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// answer the sudo password prompt through stdin so the password never shows up in the command or the output
+	var responder *sudoResponder
+	if options.Become {
+		command = becomeCommand(command, options.BecomeUser)
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to setup stdin for session: %v", err)
+		}
+		responder = &sudoResponder{
+			output:   output,
+			stdin:    stdin,
+			password: client.Config.becomePassword,
+		}
+		session.Stdout = responder
+		session.Stderr = responder
+	}
+
+	if err := session.Start(command); err != nil {
+		return nil, nil, err
 	}
+	return output, responder, nil
+}
+
+/**
+  Test executes a command on the server and reports if it exited successfully.
+  An error is returned only if the command could not be executed.
+*/
+func (client *Client) Test(command string, options ExecuteOptions) (bool, error) {
+	output, err := client.ExecuteWithOptions(command, options)
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := err.(*ssh.ExitError); ok {
+		return false, nil
+	}
+	return false, fmt.Errorf("%s %s", output, err)
+}
 
-	return string(output), nil
+/**
+  ExitStatus returns the exit status of a command from the error returned when executing it
+  or -1 if the command did not exit with a status
+*/
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+/**
+outputBuffer is a bytes.Buffer that can be written from multiple goroutines
+*/
+type outputBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (output *outputBuffer) Write(data []byte) (int, error) {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	return output.buffer.Write(data)
+}
+
+func (output *outputBuffer) String() string {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+	return output.buffer.String()
+}
+
+/**
+prepareCommand sets the environment variables on the session and changes to the working directory before the command.
+Variables the server refuses to set, or that would be dropped by sudo, are exported by the command itself.
+*/
+func prepareCommand(session *ssh.Session, command string, options ExecuteOptions) (string, error) {
+	if options.Cwd != "" {
+		// stop when the directory is missing instead of running the rest of the command in the login directory
+		command = "cd " + ShellQuote(options.Cwd) + " || exit 1; " + command
+	}
+
+	names := make([]string, 0, len(options.Env))
+	for name := range options.Env {
+		if !envNamePattern.MatchString(name) {
+			return "", fmt.Errorf("Invalid environment variable name: %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var exports []string
+	for _, name := range names {
+		value := options.Env[name]
+		if !options.Become && session.Setenv(name, value) == nil {
+			continue
+		}
+		exports = append(exports, name+"="+ShellQuote(value))
+	}
+	if len(exports) != 0 {
+		command = "export " + strings.Join(exports, " ") + "; " + command
+	}
+	return command, nil
 }
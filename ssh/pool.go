@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"sync"
+)
+
+/**
+Pool keeps one connected client per server so that it can be reused by every operation on that server
+*/
+type Pool struct {
+	mutex   sync.Mutex
+	clients map[string]*poolEntry
+}
+
+// poolEntry holds a client while it is being connected so concurrent callers wait for the same connection
+type poolEntry struct {
+	ready  chan struct{}
+	client *Client
+	err    error
+}
+
+/**
+NewPool creates an empty connection pool
+*/
+func NewPool() *Pool {
+	return &Pool{
+		clients: map[string]*poolEntry{},
+	}
+}
+
+/**
+Get returns the connected client for the named server, creating it with the config returned by newConfig if needed
+*/
+func (pool *Pool) Get(name string, newConfig func() (*Config, error)) (*Client, error) {
+	pool.mutex.Lock()
+	entry, ok := pool.clients[name]
+	if ok {
+		pool.mutex.Unlock()
+		<-entry.ready
+		return entry.client, entry.err
+	}
+	entry = &poolEntry{ready: make(chan struct{})}
+	pool.clients[name] = entry
+	pool.mutex.Unlock()
+
+	// connect outside of the lock so that other servers are not blocked
+	entry.client, entry.err = connectPooled(newConfig)
+	close(entry.ready)
+	if entry.err != nil {
+		// forget failed connections so they can be tried again later
+		pool.mutex.Lock()
+		if pool.clients[name] == entry {
+			delete(pool.clients, name)
+		}
+		pool.mutex.Unlock()
+	}
+	return entry.client, entry.err
+}
+
+/**
+Close disconnects all the clients in the pool
+*/
+func (pool *Pool) Close() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for name, entry := range pool.clients {
+		<-entry.ready
+		if entry.client != nil {
+			entry.client.Disconnect()
+		}
+		delete(pool.clients, name)
+	}
+}
+
+/**
+Create a new client and connect it to the server
+*/
+func connectPooled(newConfig func() (*Config, error)) (*Client, error) {
+	config, err := newConfig()
+	if err != nil {
+		return nil, err
+	}
+	client := New(config)
+	if err := client.TryConnection(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
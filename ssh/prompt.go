@@ -0,0 +1,29 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"strings"
+)
+
+/**
+Prompt the user for a value on the terminal, hiding the input when echo is disabled
+*/
+func Prompt(message string, echo bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("Unable to prompt for '%s': no terminal available", strings.TrimSpace(message))
+	}
+
+	fmt.Fprint(os.Stderr, message)
+	if !echo {
+		value, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(value), err
+	}
+
+	value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(value, "\r\n"), err
+}
@@ -0,0 +1,12 @@
+package ssh
+
+import (
+	"strings"
+)
+
+/**
+ShellQuote quotes a value so that it is passed as a single word to a POSIX shell
+*/
+func ShellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,102 @@
+package ssh
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"time"
+)
+
+// default command used to reboot the server
+const DefaultRebootCommand = "shutdown -r now"
+
+// time between two attempts to connect to the server while it reboots
+const rebootPollInterval = 5 * time.Second
+
+// time given to the reboot command to fail before the server is considered to be rebooting
+const rebootStartTimeout = 10 * time.Second
+
+/**
+Reboot the server using the given command and wait until it is reachable again or the timeout of the options expires.
+The command is executed with the same options as any other command, so become runs it through sudo.
+*/
+func (client *Client) Reboot(command string, options ExecuteOptions) error {
+	timeout := options.Timeout
+	if command == "" {
+		command = DefaultRebootCommand
+	}
+
+	// remember the boot id so we can tell when the server has actually restarted
+	bootId := client.bootId()
+
+	if err := client.startReboot(command, options); err != nil {
+		return err
+	}
+	client.Disconnect()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		time.Sleep(rebootPollInterval)
+		err := client.Connect()
+		if err == nil {
+			current := client.bootId()
+			if bootId == "" || current != bootId {
+				return nil
+			}
+			// the server did not go down yet
+			client.Disconnect()
+			err = fmt.Errorf("server did not restart")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Server[%s] did not come back after reboot within %s: %s", client.Config.Host, timeout, err)
+		}
+	}
+}
+
+/**
+Retrieve the unique id of the current boot of the server or an empty string if it is not available
+*/
+func (client *Client) bootId() string {
+	output, err := client.Execute("cat /proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+/**
+Start the reboot command and wait a short time for it to fail. The command usually does not finish
+since the connection is dropped by the server, so only a non zero exit status is reported as an error.
+*/
+func (client *Client) startReboot(command string, options ExecuteOptions) error {
+	client.acquireSession()
+	defer client.releaseSession()
+
+	session, err := client.StartSession(false, true)
+	if err != nil {
+		return fmt.Errorf("Unable to contact server[%s]: %s", client.Config.Host, err)
+	}
+	defer session.Close()
+
+	output, responder, err := client.startCommand(session, command, options)
+	if err != nil {
+		return err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- session.Wait()
+	}()
+	select {
+	case err := <-result:
+		// a dropped connection or a missing exit status means the server is going down
+		if responder != nil {
+			responder.Flush()
+		}
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return fmt.Errorf("Reboot command failed on server[%s] with status %d: %s", client.Config.Host, exitErr.ExitStatus(), strings.TrimSpace(output.String()))
+		}
+	case <-time.After(rebootStartTimeout):
+	}
+	return nil
+}
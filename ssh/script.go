@@ -0,0 +1,59 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+/**
+  TempDir creates a new temporary directory on the server and returns its path
+*/
+func (client *Client) TempDir() (string, error) {
+	output, err := client.Execute("mktemp -d")
+	if err != nil {
+		return "", fmt.Errorf("Unable to create temporary directory: %s %s", output, err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+/**
+  RemoveAll removes a path and everything it contains from the server
+*/
+func (client *Client) RemoveAll(path string) error {
+	output, err := client.Execute("rm -rf " + ShellQuote(path))
+	if err != nil {
+		return fmt.Errorf("Unable to remove %s: %s %s", path, output, err)
+	}
+	return nil
+}
+
+/**
+  ExecuteScript uploads a script to a temporary location, executes it with the given arguments and removes it afterwards.
+  The script is executed directly, using its shebang line, unless an interpreter is given.
+*/
+func (client *Client) ExecuteScript(name string, content []byte, arguments string, interpreter string, options ExecuteOptions) (string, error) {
+	tmpDir, err := client.TempDir()
+	if err != nil {
+		return "", err
+	}
+	defer client.RemoveAll(tmpDir)
+
+	script := path.Join(tmpDir, path.Base(name))
+	if err := client.CopyContent(bytes.NewReader(content), int64(len(content)), 0755, script); err != nil {
+		return "", fmt.Errorf("Unable to upload script %s: %s", name, err)
+	}
+	if err := client.shareWithBecomeUser(tmpDir, options); err != nil {
+		return "", err
+	}
+
+	command := ShellQuote(script)
+	if interpreter != "" {
+		command = interpreter + " " + command
+	}
+	if arguments != "" {
+		command += " " + arguments
+	}
+	return client.ExecuteWithOptions(command, options)
+}
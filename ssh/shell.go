@@ -9,6 +9,9 @@ import (
   @Todo Fix issue using arrow keys in a shell connection
 */
 func (client *Client) Shell() error {
+	client.acquireSession()
+	defer client.releaseSession()
+
 	session, err := client.StartSession(true, true)
 	if err != nil {
 		return fmt.Errorf("Unable to contact server[%s]: %s", client.Config.Host, err)
@@ -0,0 +1,134 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// values used by the SOCKS5 protocol as described in RFC 1928
+const (
+	socksVersion        = 0x05
+	socksNoAuth         = 0x00
+	socksNoAcceptable   = 0xff
+	socksCommandConnect = 0x01
+	socksAddressIPv4    = 0x01
+	socksAddressDomain  = 0x03
+	socksAddressIPv6    = 0x04
+
+	socksReplySucceeded          = 0x00
+	socksReplyHostUnreachable    = 0x04
+	socksReplyCommandUnsupported = 0x07
+	socksReplyAddressUnsupported = 0x08
+)
+
+/**
+socksServe handles a single SOCKS5 client connection and opens the requested connection using the dial function
+*/
+func socksServe(conn net.Conn, dial func(network, addr string) (net.Conn, error)) error {
+	// negotiate the authentication method, only "no authentication" is supported
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		conn.Close()
+		return err
+	}
+	if header[0] != socksVersion {
+		conn.Close()
+		return fmt.Errorf("Unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		conn.Close()
+		return err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	conn.Write([]byte{socksVersion, method})
+	if method == socksNoAcceptable {
+		conn.Close()
+		return fmt.Errorf("No supported SOCKS authentication method offered")
+	}
+
+	// read the request
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		conn.Close()
+		return err
+	}
+	if request[1] != socksCommandConnect {
+		socksReply(conn, socksReplyCommandUnsupported)
+		conn.Close()
+		return fmt.Errorf("Unsupported SOCKS command %d", request[1])
+	}
+	host, err := socksReadAddress(conn, request[3])
+	if err != nil {
+		socksReply(conn, socksReplyAddressUnsupported)
+		conn.Close()
+		return err
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		conn.Close()
+		return err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	// open the connection to the requested address
+	target, err := dial("tcp", addr)
+	if err != nil {
+		socksReply(conn, socksReplyHostUnreachable)
+		conn.Close()
+		return fmt.Errorf("Unable to connect to %s: %s", addr, err)
+	}
+	if err := socksReply(conn, socksReplySucceeded); err != nil {
+		target.Close()
+		conn.Close()
+		return err
+	}
+
+	pipe(conn, target)
+	return nil
+}
+
+/**
+Read the destination address of a SOCKS request based on its type
+*/
+func socksReadAddress(conn io.Reader, addressType byte) (string, error) {
+	switch addressType {
+	case socksAddressIPv4, socksAddressIPv6:
+		size := net.IPv4len
+		if addressType == socksAddressIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		return net.IP(ip).String(), nil
+	case socksAddressDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		return string(domain), nil
+	}
+	return "", fmt.Errorf("Unsupported SOCKS address type %d", addressType)
+}
+
+/**
+Send a reply to the SOCKS client, the bound address is not used by clients so it is always empty
+*/
+func socksReply(conn io.Writer, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0x00, socksAddressIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,284 @@
+package ssh
+
+import (
+	"fmt"
+	"github.com/Around25/shellbot/logger"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// time between two attempts to listen again on the server after the connection was lost
+const relistenInterval = 5 * time.Second
+
+/**
+Forward describes a port forwarding rule between a listening address and a target address
+*/
+type Forward struct {
+	ListenAddr string
+	TargetAddr string
+}
+
+/**
+ParseForward parses a forwarding rule in the ssh -L/-R format: [bind_address:]port:host:hostport
+*/
+func ParseForward(spec string) (Forward, error) {
+	parts := splitForwardSpec(spec)
+	switch len(parts) {
+	case 3:
+		return Forward{
+			ListenAddr: net.JoinHostPort("localhost", parts[0]),
+			TargetAddr: net.JoinHostPort(parts[1], parts[2]),
+		}, nil
+	case 4:
+		return Forward{
+			ListenAddr: net.JoinHostPort(parts[0], parts[1]),
+			TargetAddr: net.JoinHostPort(parts[2], parts[3]),
+		}, nil
+	}
+	return Forward{}, fmt.Errorf("Invalid forwarding rule '%s', expected [bind_address:]port:host:hostport", spec)
+}
+
+/**
+ParseDynamicForward parses a dynamic forwarding rule in the ssh -D format: [bind_address:]port
+*/
+func ParseDynamicForward(spec string) (string, error) {
+	parts := splitForwardSpec(spec)
+	switch len(parts) {
+	case 1:
+		return net.JoinHostPort("localhost", parts[0]), nil
+	case 2:
+		return net.JoinHostPort(parts[0], parts[1]), nil
+	}
+	return "", fmt.Errorf("Invalid dynamic forwarding rule '%s', expected [bind_address:]port", spec)
+}
+
+/**
+Split a forwarding rule by ':' while keeping IPv6 addresses written as [address] together
+*/
+func splitForwardSpec(spec string) []string {
+	var parts []string
+	for len(spec) > 0 {
+		if spec[0] == '[' {
+			end := strings.Index(spec, "]")
+			if end != -1 {
+				parts = append(parts, spec[1:end])
+				spec = strings.TrimPrefix(spec[end+1:], ":")
+				continue
+			}
+		}
+		index := strings.Index(spec, ":")
+		if index == -1 {
+			parts = append(parts, spec)
+			break
+		}
+		parts = append(parts, spec[:index])
+		spec = spec[index+1:]
+	}
+	return parts
+}
+
+/**
+ForwardLocal listens on a local address and forwards every connection to the target address through the server
+*/
+func (client *Client) ForwardLocal(forward Forward) (net.Listener, error) {
+	if _, err := client.connection(); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", forward.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on %s: %s", forward.ListenAddr, err)
+	}
+
+	go acceptConnections(listener, func(local net.Conn) {
+		remote, err := client.dial("tcp", forward.TargetAddr)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("Unable to connect to %s through server[%s]: %s", forward.TargetAddr, client.Config.Host, err))
+			local.Close()
+			return
+		}
+		pipe(local, remote)
+	})
+	return listener, nil
+}
+
+/**
+ForwardRemote listens on an address of the server and forwards every connection to the target address on the local side.
+If the connection to the server is lost the client reconnects and listens on the server again.
+*/
+func (client *Client) ForwardRemote(forward Forward) (net.Listener, error) {
+	conn, err := client.connection()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := conn.Listen("tcp", forward.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on %s on server[%s]: %s", forward.ListenAddr, client.Config.Host, err)
+	}
+	listener := &remoteListener{client: client, addr: forward.ListenAddr, conn: conn, listener: remote}
+
+	go acceptConnections(listener, func(remote net.Conn) {
+		local, err := net.Dial("tcp", forward.TargetAddr)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("Unable to connect to %s: %s", forward.TargetAddr, err))
+			remote.Close()
+			return
+		}
+		pipe(remote, local)
+	})
+	return listener, nil
+}
+
+/**
+ForwardDynamic starts a SOCKS5 proxy on a local address that opens every requested connection through the server
+*/
+func (client *Client) ForwardDynamic(listenAddr string) (net.Listener, error) {
+	if _, err := client.connection(); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on %s: %s", listenAddr, err)
+	}
+
+	go acceptConnections(listener, func(local net.Conn) {
+		if err := socksServe(local, client.dial); err != nil {
+			logger.Warning(fmt.Sprintf("SOCKS connection through server[%s] failed: %s", client.Config.Host, err))
+		}
+	})
+	return listener, nil
+}
+
+/**
+Open a connection from the server to the given address, dialing the server again if the connection was lost
+*/
+func (client *Client) dial(network, addr string) (net.Conn, error) {
+	conn, err := client.connection()
+	if err != nil {
+		return nil, err
+	}
+	target, err := conn.Dial(network, addr)
+	if _, refused := err.(*ssh.OpenChannelError); err != nil && !refused {
+		// only reconnect when the connection itself is broken, a refused target leaves it usable
+		if conn, reconnectErr := client.reconnect(conn); reconnectErr == nil {
+			target, err = conn.Dial(network, addr)
+		}
+	}
+	return target, err
+}
+
+/**
+remoteListener accepts the connections forwarded from an address of the server
+and listens on the server again when the connection is lost
+*/
+type remoteListener struct {
+	client *Client
+	addr   string
+
+	mutex    sync.Mutex
+	conn     *ssh.Client
+	listener net.Listener
+	closed   bool
+}
+
+func (remote *remoteListener) Accept() (net.Conn, error) {
+	for {
+		remote.mutex.Lock()
+		listener, closed := remote.listener, remote.closed
+		remote.mutex.Unlock()
+		if closed {
+			return nil, fmt.Errorf("Listener on %s closed", remote.addr)
+		}
+
+		conn, err := listener.Accept()
+		if err == nil {
+			return conn, nil
+		}
+		remote.relisten()
+	}
+}
+
+/**
+Reconnect to the server and listen on the address again, retrying until it works or the listener is closed
+*/
+func (remote *remoteListener) relisten() {
+	for {
+		remote.mutex.Lock()
+		if remote.closed {
+			remote.mutex.Unlock()
+			return
+		}
+		broken := remote.conn
+		remote.mutex.Unlock()
+
+		conn, err := remote.client.reconnect(broken)
+		if err == nil {
+			var listener net.Listener
+			if listener, err = conn.Listen("tcp", remote.addr); err == nil {
+				remote.mutex.Lock()
+				defer remote.mutex.Unlock()
+				if remote.closed {
+					listener.Close()
+					return
+				}
+				remote.conn, remote.listener = conn, listener
+				logger.Info(fmt.Sprintf("Listening again on %s on server[%s]", remote.addr, remote.client.Config.Host))
+				return
+			}
+			// listening failed on the new connection so treat it as broken as well
+			remote.mutex.Lock()
+			remote.conn = conn
+			remote.mutex.Unlock()
+		}
+		logger.Warning(fmt.Sprintf("Unable to listen again on %s on server[%s]: %s", remote.addr, remote.client.Config.Host, err))
+		time.Sleep(relistenInterval)
+	}
+}
+
+func (remote *remoteListener) Close() error {
+	remote.mutex.Lock()
+	defer remote.mutex.Unlock()
+	remote.closed = true
+	return remote.listener.Close()
+}
+
+func (remote *remoteListener) Addr() net.Addr {
+	remote.mutex.Lock()
+	defer remote.mutex.Unlock()
+	return remote.listener.Addr()
+}
+
+/**
+Accept connections until the listener is closed and handle each one in a separate goroutine
+*/
+func acceptConnections(listener net.Listener, handle func(net.Conn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handle(conn)
+	}
+}
+
+/**
+Copy data in both directions between two connections and close both when either side is done
+*/
+func pipe(first, second net.Conn) {
+	defer first.Close()
+	defer second.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(first, second)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(second, first)
+		done <- struct{}{}
+	}()
+	<-done
+}
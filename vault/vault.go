@@ -0,0 +1,191 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Header marks the content of a file encrypted with the vault
+const Header = "$SHELLBOT_VAULT;1.0;AES256-GCM"
+
+// ValuePrefix marks a single encrypted value inside a configuration file
+const ValuePrefix = "vault:"
+
+// tagPattern matches a YAML !vault tag along with the quote and the prefix starting the value it applies to
+var tagPattern = regexp.MustCompile(`(?m)(^|[:\-,\[][ \t]*)!vault([ \t]+(["']?)(?:` + ValuePrefix + `)?)?`)
+
+// parameters used to derive the encryption key from the passphrase
+const (
+	saltSize  = 16
+	keySize   = 32
+	scryptN   = 32768
+	scryptR   = 8
+	scryptP   = 1
+	lineWidth = 80
+)
+
+/**
+Encrypt the data with a key derived from the passphrase and return it as the header followed by base64 lines
+*/
+func Encrypt(data []byte, passphrase string) ([]byte, error) {
+	payload, err := seal(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var result bytes.Buffer
+	result.WriteString(Header + "\n")
+	for len(payload) > lineWidth {
+		result.WriteString(payload[:lineWidth] + "\n")
+		payload = payload[lineWidth:]
+	}
+	result.WriteString(payload + "\n")
+	return result.Bytes(), nil
+}
+
+/**
+Decrypt data previously encrypted with Encrypt
+*/
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("The data is not encrypted with the vault")
+	}
+	content := strings.TrimPrefix(strings.TrimSpace(string(data)), Header)
+	return open(strings.Join(strings.Fields(content), ""), passphrase)
+}
+
+/**
+Check if the data is the content of a file encrypted with the vault
+*/
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(Header))
+}
+
+/**
+Encrypt a single value so it can be used in a configuration file, the result starts with the vault: prefix
+*/
+func EncryptString(value string, passphrase string) (string, error) {
+	payload, err := seal([]byte(value), passphrase)
+	if err != nil {
+		return "", err
+	}
+	return ValuePrefix + payload, nil
+}
+
+/**
+Decrypt a single value starting with the vault: prefix or holding the content of an encrypted file
+*/
+func DecryptString(value string, passphrase string) (string, error) {
+	var data []byte
+	var err error
+	if IsEncrypted([]byte(value)) {
+		data, err = Decrypt([]byte(value), passphrase)
+	} else {
+		data, err = open(strings.TrimPrefix(strings.TrimSpace(value), ValuePrefix), passphrase)
+	}
+	return string(data), err
+}
+
+/**
+Check if a value from a configuration file is encrypted with the vault
+*/
+func IsEncryptedString(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), ValuePrefix) || IsEncrypted([]byte(value))
+}
+
+/**
+Replace the !vault YAML tags of a configuration file by the vault: prefix, since the YAML parser drops unknown tags
+and would load the encrypted values as they are. The tagged value must be on the same line as the tag.
+*/
+func ReplaceTags(data []byte) ([]byte, error) {
+	var result bytes.Buffer
+	last := 0
+	for _, match := range tagPattern.FindAllSubmatchIndex(data, -1) {
+		end := match[1]
+		if match[4] == -1 || end >= len(data) || strings.IndexByte("|>\r\n#", data[end]) != -1 {
+			line := bytes.Count(data[:match[0]], []byte("\n")) + 1
+			return nil, fmt.Errorf("Unsupported value tagged with !vault on line %d, the encrypted value must follow the tag on the same line", line)
+		}
+		result.Write(data[last:match[3]])
+		result.Write(data[match[6]:match[7]])
+		result.WriteString(ValuePrefix)
+		last = end
+	}
+	result.Write(data[last:])
+	return result.Bytes(), nil
+}
+
+/**
+Encrypt the data using AES-GCM and return the salt, nonce and ciphertext encoded as base64
+*/
+func seal(data []byte, passphrase string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", fmt.Errorf("Unable to generate salt: %s", err)
+	}
+	aead, err := newCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("Unable to generate nonce: %s", err)
+	}
+
+	payload := append(salt, nonce...)
+	payload = aead.Seal(payload, nonce, data, []byte(Header))
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+/**
+Decode and decrypt a payload created by seal
+*/
+func open(encoded string, passphrase string) ([]byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid vault data: %s", err)
+	}
+	if len(payload) < saltSize {
+		return nil, fmt.Errorf("Invalid vault data: too short")
+	}
+	aead, err := newCipher(passphrase, payload[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[saltSize:]
+	if len(payload) < aead.NonceSize() {
+		return nil, fmt.Errorf("Invalid vault data: too short")
+	}
+
+	data, err := aead.Open(nil, payload[:aead.NonceSize()], payload[aead.NonceSize():], []byte(Header))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt vault data, the password is wrong or the data was modified")
+	}
+	return data, nil
+}
+
+/**
+Create the AES-GCM cipher using a key derived from the passphrase with scrypt
+*/
+func newCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("The vault password is empty")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to derive vault key: %s", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,120 @@
+package vault
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	data := []byte("servers:\n  web:\n    uri: root:s3cret@example.com\n")
+	encrypted, err := Encrypt(data, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) {
+		t.Fatalf("Encrypted data does not start with the header: %s", encrypted)
+	}
+	if strings.Contains(string(encrypted), "s3cret") {
+		t.Fatalf("Encrypted data contains the plain text")
+	}
+
+	decrypted, err := Decrypt(encrypted, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != string(data) {
+		t.Errorf("Decrypt returned %q, expected %q", decrypted, data)
+	}
+	if _, err := Decrypt(encrypted, "wrong"); err == nil {
+		t.Errorf("Decrypt succeeded with the wrong password")
+	}
+	if _, err := Decrypt(data, "password"); err == nil {
+		t.Errorf("Decrypt succeeded on data that is not encrypted")
+	}
+}
+
+func TestEncryptDecryptString(t *testing.T) {
+	encrypted, err := EncryptString("s3cret", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, ValuePrefix) || !IsEncryptedString(encrypted) {
+		t.Fatalf("EncryptString returned %q without the %s prefix", encrypted, ValuePrefix)
+	}
+
+	for _, value := range []string{encrypted, " " + encrypted + "\n"} {
+		decrypted, err := DecryptString(value, "password")
+		if err != nil || decrypted != "s3cret" {
+			t.Errorf("DecryptString(%q) = %q, %v, expected s3cret", value, decrypted, err)
+		}
+	}
+	if IsEncryptedString("s3cret") {
+		t.Errorf("IsEncryptedString reported a plain value as encrypted")
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	encrypted, err := EncryptString("s3cret", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, ValuePrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]byte{
+		"salt":       flipByte(payload, 0),
+		"nonce":      flipByte(payload, saltSize),
+		"ciphertext": flipByte(payload, len(payload)-1),
+		"truncated":  payload[:saltSize+4],
+	}
+	for name, tampered := range tests {
+		value := ValuePrefix + base64.StdEncoding.EncodeToString(tampered)
+		if _, err := DecryptString(value, "password"); err == nil {
+			t.Errorf("DecryptString succeeded with a modified %s", name)
+		}
+	}
+	if _, err := DecryptString(ValuePrefix+"not base64!", "password"); err == nil {
+		t.Errorf("DecryptString succeeded with invalid base64")
+	}
+}
+
+func TestReplaceTags(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+		err      bool
+	}{
+		{data: "password: !vault abc=\n", expected: "password: vault:abc=\n"},
+		{data: "password: !vault vault:abc=\n", expected: "password: vault:abc=\n"},
+		{data: "password: !vault \"abc=\"\n", expected: "password: \"vault:abc=\"\n"},
+		{data: "password:   !vault   'abc='\n", expected: "password:   'vault:abc='\n"},
+		{data: "list:\n  - !vault abc=\n", expected: "list:\n  - vault:abc=\n"},
+		{data: "list: [!vault abc=, plain]\n", expected: "list: [vault:abc=, plain]\n"},
+		{data: "name: my!vault value\n", expected: "name: my!vault value\n"},
+		{data: "password: vault:abc=\n", expected: "password: vault:abc=\n"},
+		{data: "password: !vault |\n  abc=\n", err: true},
+		{data: "password: !vault\n  abc=\n", err: true},
+		{data: "password: !vault", err: true},
+	}
+	for _, test := range tests {
+		result, err := ReplaceTags([]byte(test.data))
+		if test.err {
+			if err == nil {
+				t.Errorf("ReplaceTags(%q) = %q, expected an error", test.data, result)
+			}
+			continue
+		}
+		if err != nil || string(result) != test.expected {
+			t.Errorf("ReplaceTags(%q) = %q, %v, expected %q", test.data, result, err, test.expected)
+		}
+	}
+}
+
+func flipByte(data []byte, index int) []byte {
+	result := append([]byte(nil), data...)
+	result[index] ^= 0xff
+	return result
+}